@@ -0,0 +1,549 @@
+package hystrix
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceProvider supplies the list of peer "hystrix.stream" URLs an
+// AggregatorHandler should merge. The default NewAggregatorHandler
+// backs this with a static list; implementations backed by DNS-SRV
+// lookups or a file watcher can be plugged in via
+// NewAggregatorHandlerWithProvider for dynamic fleets.
+type SourceProvider interface {
+	Sources() []string
+}
+
+type staticSourceProvider []string
+
+func (s staticSourceProvider) Sources() []string { return []string(s) }
+
+// AggregatorHandler is a Turbine-style aggregator: it opens long-lived
+// SSE connections to a set of peer hystrix.stream endpoints, groups
+// the events it receives by circuit name, and serves a single merged
+// stream that stays wire-compatible with the Hystrix dashboard.
+type AggregatorHandler struct {
+	provider SourceProvider
+
+	mu       sync.RWMutex
+	requests map[*http.Request]chan []byte
+	running  bool
+
+	hostsMu   sync.Mutex
+	hosts     map[string]map[string]hostSnapshot     // circuit name -> host -> latest snapshot
+	poolHosts map[string]map[string]hostPoolSnapshot // pool name -> host -> latest snapshot
+
+	sourcesMu sync.Mutex
+	sources   map[string]chan struct{} // source -> its consume goroutine's stop channel
+
+	done chan struct{}
+}
+
+// hostSnapshot is the most recent event a single peer reported for a
+// circuit, kept just long enough to be folded into the next merged
+// tick.
+type hostSnapshot struct {
+	metric streamCmdMetric
+	seenAt time.Time
+}
+
+// hostPoolSnapshot is hostSnapshot's counterpart for thread-pool
+// events.
+type hostPoolSnapshot struct {
+	metric streamThreadPoolMetric
+	seenAt time.Time
+}
+
+// NewAggregatorHandler merges the hystrix.stream output of sources
+// into one stream.
+func NewAggregatorHandler(sources []string) *AggregatorHandler {
+	return NewAggregatorHandlerWithProvider(staticSourceProvider(sources))
+}
+
+// sourceRefreshInterval is how often loop re-polls the SourceProvider
+// for added or removed peers. It rides the same ticker as publish's
+// once-a-second merge, rather than a timer of its own, since a dynamic
+// fleet doesn't need sub-second reaction time and one ticker is one
+// fewer goroutine to manage.
+const sourceRefreshInterval = 1 * time.Second
+
+// NewAggregatorHandlerWithProvider is like NewAggregatorHandler but
+// takes a SourceProvider, for fleets whose membership changes at
+// runtime: loop re-polls provider.Sources() every sourceRefreshInterval
+// and starts or stops a consume goroutine for each source added or
+// removed since the last poll.
+func NewAggregatorHandlerWithProvider(provider SourceProvider) *AggregatorHandler {
+	a := &AggregatorHandler{
+		provider:  provider,
+		requests:  make(map[*http.Request]chan []byte),
+		running:   true,
+		hosts:     make(map[string]map[string]hostSnapshot),
+		poolHosts: make(map[string]map[string]hostPoolSnapshot),
+		sources:   make(map[string]chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	a.reconcileSources()
+	go a.loop()
+
+	return a
+}
+
+// reconcileSources diffs the SourceProvider's current Sources() against
+// the set of sources already being consumed, starting a consume
+// goroutine for each one that's new and stopping it for each one
+// that's gone.
+func (a *AggregatorHandler) reconcileSources() {
+	current := make(map[string]bool)
+	for _, src := range a.provider.Sources() {
+		current[src] = true
+	}
+
+	a.sourcesMu.Lock()
+	defer a.sourcesMu.Unlock()
+
+	for src := range current {
+		if _, ok := a.sources[src]; !ok {
+			stop := make(chan struct{})
+			a.sources[src] = stop
+			go a.consume(src, stop)
+		}
+	}
+	for src, stop := range a.sources {
+		if !current[src] {
+			close(stop)
+			delete(a.sources, src)
+		}
+	}
+}
+
+// Stop disconnects from every peer and stops serving merged events.
+// Every client blocked in ServeHTTP's range over its event channel is
+// released by closing that channel, the same way StreamHandler.Stop
+// does. It is safe to call multiple times.
+func (a *AggregatorHandler) Stop() {
+	a.mu.Lock()
+	if !a.running {
+		a.mu.Unlock()
+		return
+	}
+	a.running = false
+	a.mu.Unlock()
+
+	close(a.done)
+
+	a.sourcesMu.Lock()
+	for src, stop := range a.sources {
+		close(stop)
+		delete(a.sources, src)
+	}
+	a.sourcesMu.Unlock()
+
+	a.mu.Lock()
+	for req, events := range a.requests {
+		close(events)
+		delete(a.requests, req)
+	}
+	a.mu.Unlock()
+}
+
+var _ http.Handler = (*AggregatorHandler)(nil)
+
+// ServeHTTP streams merged events in the JSON SSE format, like
+// StreamHandler.ServeHTTP's default encoder. It does not negotiate the
+// protobuf or gzip StreamEncoders from the request headers.
+func (a *AggregatorHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	events, ok := a.register(req)
+	if !ok {
+		http.Error(rw, "hystrix: AggregatorHandler is not running", http.StatusServiceUnavailable)
+		return
+	}
+	defer a.unregister(req)
+	rw.Header().Add("Content-Type", "text/event-stream")
+	for event := range events {
+		if _, err := rw.Write(event); err != nil {
+			return
+		}
+		if f, ok := rw.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// register adds req as a connected client and returns its event
+// channel, or returns ok == false if the handler has already been
+// stopped -- the same reject-after-stop check StreamHandler.register
+// does, and for the same reason: without it a client that calls
+// ServeHTTP just after Stop would be added to a.requests but never
+// serviced or closed, and would block in its range over events
+// forever.
+func (a *AggregatorHandler) register(req *http.Request) (events <-chan []byte, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.running {
+		return nil, false
+	}
+	ch := make(chan []byte, streamEventBufferSize)
+	a.requests[req] = ch
+	return ch, true
+}
+
+func (a *AggregatorHandler) unregister(req *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.requests, req)
+}
+
+// consume maintains a long-lived SSE connection to a single peer,
+// reconnecting with exponential backoff (capped at 30s) whenever the
+// connection drops. It returns once a.done closes or stop closes --
+// stop closes when reconcileSources notices source is no longer
+// returned by the SourceProvider.
+func (a *AggregatorHandler) consume(source string, stop <-chan struct{}) {
+	backoff := time.Second
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-stop:
+			return
+		default:
+		}
+
+		if err := a.readStream(source, stop); err != nil {
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > 30*time.Second {
+				backoff = 30 * time.Second
+			}
+			continue
+		}
+		backoff = time.Second
+	}
+}
+
+// readStream opens one long-lived connection to source and ingests
+// events from it until the response ends, a.done closes, or stop
+// closes. The request carries a context tied to both channels so that
+// a Stop() or source removal while scanner.Scan() is blocked reading
+// an idle connection aborts the read and unblocks the goroutine
+// instead of leaking it (and the underlying connection) for good.
+func (a *AggregatorHandler) readStream(source string, stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-a.done:
+			cancel()
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		select {
+		case <-a.done:
+			return nil
+		case <-stop:
+			return nil
+		default:
+		}
+
+		line := strings.TrimPrefix(scanner.Text(), "data:")
+		if line == scanner.Text() || line == "" {
+			continue
+		}
+		a.ingest(source, []byte(line))
+	}
+	return scanner.Err()
+}
+
+// ingest parses a peer event and folds it into the per-circuit or
+// per-pool snapshot it updates, based on its "type" discriminator.
+// Events of any other type (or that fail to parse) are ignored.
+func (a *AggregatorHandler) ingest(source string, data []byte) {
+	var probe struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return
+	}
+
+	switch probe.Type {
+	case "HystrixCommand":
+		a.ingestCmdMetric(source, data)
+	case "HystrixThreadPool":
+		a.ingestThreadPoolMetric(source, data)
+	}
+}
+
+func (a *AggregatorHandler) ingestCmdMetric(source string, data []byte) {
+	var metric streamCmdMetric
+	if err := json.Unmarshal(data, &metric); err != nil {
+		return
+	}
+
+	a.hostsMu.Lock()
+	defer a.hostsMu.Unlock()
+	byHost, ok := a.hosts[metric.Name]
+	if !ok {
+		byHost = make(map[string]hostSnapshot)
+		a.hosts[metric.Name] = byHost
+	}
+	byHost[source] = hostSnapshot{metric: metric, seenAt: time.Now()}
+}
+
+func (a *AggregatorHandler) ingestThreadPoolMetric(source string, data []byte) {
+	var metric streamThreadPoolMetric
+	if err := json.Unmarshal(data, &metric); err != nil {
+		return
+	}
+
+	a.hostsMu.Lock()
+	defer a.hostsMu.Unlock()
+	byHost, ok := a.poolHosts[metric.Name]
+	if !ok {
+		byHost = make(map[string]hostPoolSnapshot)
+		a.poolHosts[metric.Name] = byHost
+	}
+	byHost[source] = hostPoolSnapshot{metric: metric, seenAt: time.Now()}
+}
+
+// loop emits one merged event per circuit and per pool every second,
+// dropping hosts that haven't reported in the last rolling window, and
+// re-polls the SourceProvider on the same cadence so added or removed
+// peers are picked up without a restart.
+func (a *AggregatorHandler) loop() {
+	tick := time.Tick(sourceRefreshInterval)
+	for {
+		select {
+		case <-tick:
+			a.reconcileSources()
+			a.publish()
+		case <-a.done:
+			return
+		}
+	}
+}
+
+func (a *AggregatorHandler) publish() {
+	a.hostsMu.Lock()
+	merged := make([]streamCmdMetric, 0, len(a.hosts))
+	mergedPools := make([]streamThreadPoolMetric, 0, len(a.poolHosts))
+	cutoff := time.Now().Add(-DefaultRollingStatsWindow * time.Millisecond)
+	for name, byHost := range a.hosts {
+		var reporting []streamCmdMetric
+		for host, snap := range byHost {
+			if snap.seenAt.Before(cutoff) {
+				delete(byHost, host)
+				continue
+			}
+			reporting = append(reporting, snap.metric)
+		}
+		if len(reporting) == 0 {
+			continue
+		}
+		merged = append(merged, mergeHostMetrics(name, reporting))
+	}
+	for name, byHost := range a.poolHosts {
+		var reporting []streamThreadPoolMetric
+		for host, snap := range byHost {
+			if snap.seenAt.Before(cutoff) {
+				delete(byHost, host)
+				continue
+			}
+			reporting = append(reporting, snap.metric)
+		}
+		if len(reporting) == 0 {
+			continue
+		}
+		mergedPools = append(mergedPools, mergeHostThreadPoolMetrics(name, reporting))
+	}
+	a.hostsMu.Unlock()
+
+	for _, m := range merged {
+		eventBytes, err := json.Marshal(&m)
+		if err != nil {
+			continue
+		}
+		a.writeToRequests(eventBytes)
+	}
+	for _, m := range mergedPools {
+		eventBytes, err := json.Marshal(&m)
+		if err != nil {
+			continue
+		}
+		a.writeToRequests(eventBytes)
+	}
+}
+
+// mergeHostMetrics combines every reporting host's latest snapshot for
+// a circuit into one event: rolling counters are summed, ErrorPct is
+// recomputed from the summed counts, CircuitBreakerOpen is true if
+// any host reports open, and latency percentiles are combined with a
+// request-count-weighted average across hosts -- a light-weight
+// stand-in for merging per-host t-digests when only the already
+// summarized percentiles (not the raw samples) are available.
+func mergeHostMetrics(name string, hosts []streamCmdMetric) streamCmdMetric {
+	merged := hosts[0]
+	merged.Name = name
+	merged.Group = name
+	merged.Time = currentTime()
+	merged.ReportingHosts = uint32(len(hosts))
+	merged.CircuitBreakerOpen = false
+
+	merged.RequestCount = 0
+	merged.ErrorCount = 0
+	merged.RollingCountSuccess = 0
+	merged.RollingCountFailure = 0
+	merged.RollingCountThreadPoolRejected = 0
+	merged.RollingCountShortCircuited = 0
+	merged.RollingCountTimeout = 0
+	merged.RollingCountFallbackSuccess = 0
+	merged.RollingCountFallbackFailure = 0
+	merged.RollingCountCollapsedRequests = 0
+	merged.RollingCountResponsesFromCache = 0
+
+	digest := newLatencyDigest()
+	for _, h := range hosts {
+		merged.RequestCount += h.RequestCount
+		merged.ErrorCount += h.ErrorCount
+		merged.RollingCountSuccess += h.RollingCountSuccess
+		merged.RollingCountFailure += h.RollingCountFailure
+		merged.RollingCountThreadPoolRejected += h.RollingCountThreadPoolRejected
+		merged.RollingCountShortCircuited += h.RollingCountShortCircuited
+		merged.RollingCountTimeout += h.RollingCountTimeout
+		merged.RollingCountFallbackSuccess += h.RollingCountFallbackSuccess
+		merged.RollingCountFallbackFailure += h.RollingCountFallbackFailure
+		merged.RollingCountCollapsedRequests += h.RollingCountCollapsedRequests
+		merged.RollingCountResponsesFromCache += h.RollingCountResponsesFromCache
+		if h.CircuitBreakerOpen {
+			merged.CircuitBreakerOpen = true
+		}
+		digest.add(h.LatencyTotal, h.RequestCount)
+	}
+
+	if merged.RequestCount > 0 {
+		merged.ErrorPct = merged.ErrorCount * 100 / merged.RequestCount
+	} else {
+		merged.ErrorPct = 0
+	}
+	merged.LatencyTotal = digest.merge()
+
+	return merged
+}
+
+// mergeHostThreadPoolMetrics combines every reporting host's latest
+// thread-pool snapshot into one event: execution counters are summed
+// across hosts, RollingMaxActiveThreads takes the largest value any
+// host reported, and the remaining fields -- pool sizing and
+// configuration, which don't vary by request volume -- are taken from
+// the first reporting host.
+func mergeHostThreadPoolMetrics(name string, hosts []streamThreadPoolMetric) streamThreadPoolMetric {
+	merged := hosts[0]
+	merged.Name = name
+	merged.ReportingHosts = uint32(len(hosts))
+
+	merged.CurrentActiveCount = 0
+	merged.CurrentCompletedTaskCount = 0
+	merged.CurrentQueueSize = 0
+	merged.CurrentTaskCount = 0
+	merged.RollingMaxActiveThreads = 0
+	merged.RollingCountThreadsExecuted = 0
+
+	for _, h := range hosts {
+		merged.CurrentActiveCount += h.CurrentActiveCount
+		merged.CurrentCompletedTaskCount += h.CurrentCompletedTaskCount
+		merged.CurrentQueueSize += h.CurrentQueueSize
+		merged.CurrentTaskCount += h.CurrentTaskCount
+		merged.RollingCountThreadsExecuted += h.RollingCountThreadsExecuted
+		if h.RollingMaxActiveThreads > merged.RollingMaxActiveThreads {
+			merged.RollingMaxActiveThreads = h.RollingMaxActiveThreads
+		}
+	}
+
+	return merged
+}
+
+// latencyDigest approximates merging several hosts' latency
+// percentiles by weighting each host's reported percentiles by how
+// many requests it served, rather than a true t-digest over raw
+// samples.
+type latencyDigest struct {
+	weight float64
+	sum    streamCmdLatency
+}
+
+func newLatencyDigest() *latencyDigest {
+	return &latencyDigest{}
+}
+
+func (d *latencyDigest) add(l streamCmdLatency, requests uint32) {
+	w := float64(requests)
+	if w == 0 {
+		w = 1
+	}
+	d.weight += w
+	d.sum.Timing0 += uint32(float64(l.Timing0) * w)
+	d.sum.Timing25 += uint32(float64(l.Timing25) * w)
+	d.sum.Timing50 += uint32(float64(l.Timing50) * w)
+	d.sum.Timing75 += uint32(float64(l.Timing75) * w)
+	d.sum.Timing90 += uint32(float64(l.Timing90) * w)
+	d.sum.Timing95 += uint32(float64(l.Timing95) * w)
+	d.sum.Timing99 += uint32(float64(l.Timing99) * w)
+	d.sum.Timing995 += uint32(float64(l.Timing995) * w)
+	d.sum.Timing100 += uint32(float64(l.Timing100) * w)
+}
+
+func (d *latencyDigest) merge() streamCmdLatency {
+	if d.weight == 0 {
+		return streamCmdLatency{}
+	}
+	return streamCmdLatency{
+		Timing0:   uint32(float64(d.sum.Timing0) / d.weight),
+		Timing25:  uint32(float64(d.sum.Timing25) / d.weight),
+		Timing50:  uint32(float64(d.sum.Timing50) / d.weight),
+		Timing75:  uint32(float64(d.sum.Timing75) / d.weight),
+		Timing90:  uint32(float64(d.sum.Timing90) / d.weight),
+		Timing95:  uint32(float64(d.sum.Timing95) / d.weight),
+		Timing99:  uint32(float64(d.sum.Timing99) / d.weight),
+		Timing995: uint32(float64(d.sum.Timing995) / d.weight),
+		Timing100: uint32(float64(d.sum.Timing100) / d.weight),
+	}
+}
+
+func (a *AggregatorHandler) writeToRequests(eventBytes []byte) {
+	var b strings.Builder
+	b.WriteString("data:")
+	b.Write(eventBytes)
+	b.WriteString("\n\n")
+	dataBytes := []byte(b.String())
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, requestEvents := range a.requests {
+		select {
+		case requestEvents <- dataBytes:
+		default:
+		}
+	}
+}
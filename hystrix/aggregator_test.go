@@ -0,0 +1,257 @@
+package hystrix
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeSourceProvider is a SourceProvider whose Sources() can be
+// changed after construction, so tests can exercise
+// reconcileSources's add/remove diffing.
+type fakeSourceProvider struct {
+	mu      sync.Mutex
+	sources []string
+}
+
+func (f *fakeSourceProvider) Sources() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.sources...)
+}
+
+func (f *fakeSourceProvider) set(sources []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sources = sources
+}
+
+func TestMergeHostMetrics(t *testing.T) {
+	Convey("given two hosts reporting for the same circuit", t, func() {
+		hostA := streamCmdMetric{
+			Name:                "my-circuit",
+			RequestCount:        10,
+			ErrorCount:          1,
+			RollingCountSuccess: 9,
+			RollingCountFailure: 1,
+			CircuitBreakerOpen:  false,
+			LatencyTotal:        streamCmdLatency{Timing50: 10, Timing99: 100},
+		}
+		hostB := streamCmdMetric{
+			Name:                "my-circuit",
+			RequestCount:        30,
+			ErrorCount:          3,
+			RollingCountSuccess: 27,
+			RollingCountFailure: 3,
+			CircuitBreakerOpen:  true,
+			LatencyTotal:        streamCmdLatency{Timing50: 30, Timing99: 300},
+		}
+
+		merged := mergeHostMetrics("my-circuit", []streamCmdMetric{hostA, hostB})
+
+		Convey("rolling counters are summed across hosts", func() {
+			So(merged.RequestCount, ShouldEqual, 40)
+			So(merged.ErrorCount, ShouldEqual, 4)
+			So(merged.RollingCountSuccess, ShouldEqual, 36)
+			So(merged.RollingCountFailure, ShouldEqual, 4)
+		})
+
+		Convey("ErrorPct is recomputed from the summed counts, not averaged", func() {
+			So(merged.ErrorPct, ShouldEqual, 10)
+		})
+
+		Convey("CircuitBreakerOpen is true if any host reports open", func() {
+			So(merged.CircuitBreakerOpen, ShouldBeTrue)
+		})
+
+		Convey("ReportingHosts reflects how many hosts contributed", func() {
+			So(merged.ReportingHosts, ShouldEqual, 2)
+		})
+
+		Convey("latency percentiles are weighted by each host's request count", func() {
+			// hostB served 3x hostA's requests, so the merged value sits
+			// closer to hostB's than a plain average would.
+			So(merged.LatencyTotal.Timing50, ShouldEqual, 25)
+			So(merged.LatencyTotal.Timing99, ShouldEqual, 250)
+		})
+	})
+
+	Convey("given no requests served by any host", t, func() {
+		hosts := []streamCmdMetric{{Name: "idle-circuit"}}
+		merged := mergeHostMetrics("idle-circuit", hosts)
+
+		Convey("ErrorPct falls back to zero instead of dividing by zero", func() {
+			So(merged.ErrorPct, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestAggregatorHandlerStaleHostEviction(t *testing.T) {
+	Convey("given an AggregatorHandler with a host that stopped reporting", t, func() {
+		a := NewAggregatorHandlerWithProvider(staticSourceProvider(nil))
+		defer a.Stop()
+
+		a.hostsMu.Lock()
+		a.hosts["my-circuit"] = map[string]hostSnapshot{
+			"stale-host": {
+				metric: streamCmdMetric{Name: "my-circuit", RequestCount: 5},
+				seenAt: time.Now().Add(-time.Hour),
+			},
+		}
+		a.hostsMu.Unlock()
+
+		Convey("publish drops the stale host and skips the circuit entirely", func() {
+			a.publish()
+
+			a.hostsMu.Lock()
+			byHost, exists := a.hosts["my-circuit"]
+			a.hostsMu.Unlock()
+
+			So(exists, ShouldBeTrue)
+			So(byHost, ShouldBeEmpty)
+		})
+	})
+
+	Convey("given an AggregatorHandler with a freshly reporting host", t, func() {
+		a := NewAggregatorHandlerWithProvider(staticSourceProvider(nil))
+		defer a.Stop()
+
+		a.hostsMu.Lock()
+		a.hosts["my-circuit"] = map[string]hostSnapshot{
+			"fresh-host": {
+				metric: streamCmdMetric{Name: "my-circuit", RequestCount: 5},
+				seenAt: time.Now(),
+			},
+		}
+		a.hostsMu.Unlock()
+
+		Convey("publish keeps the host and merges its snapshot onto a registered client", func() {
+			req, _ := http.NewRequest("GET", "/", nil)
+			events, ok := a.register(req)
+			So(ok, ShouldBeTrue)
+			defer a.unregister(req)
+
+			a.publish()
+
+			select {
+			case event := <-events:
+				So(string(event), ShouldContainSubstring, "my-circuit")
+			case <-time.After(time.Second):
+				t.Fatal("publish did not deliver a merged event to the registered client")
+			}
+		})
+	})
+}
+
+func TestAggregatorHandlerThreadPoolForwarding(t *testing.T) {
+	Convey("given an AggregatorHandler receiving a HystrixThreadPool event from a peer", t, func() {
+		a := NewAggregatorHandlerWithProvider(staticSourceProvider(nil))
+		defer a.Stop()
+
+		metric := streamThreadPoolMetric{
+			Type:                        "HystrixThreadPool",
+			Name:                        "my-pool",
+			CurrentActiveCount:          3,
+			RollingCountThreadsExecuted: 99,
+		}
+		data, err := json.Marshal(&metric)
+		So(err, ShouldBeNil)
+
+		a.ingest("peer-a", data)
+
+		Convey("it is grouped under poolHosts by name, not silently dropped", func() {
+			a.hostsMu.Lock()
+			byHost, ok := a.poolHosts["my-pool"]
+			a.hostsMu.Unlock()
+
+			So(ok, ShouldBeTrue)
+			So(len(byHost), ShouldEqual, 1)
+			So(byHost["peer-a"].metric.CurrentActiveCount, ShouldEqual, 3)
+		})
+
+		Convey("publish merges it and forwards it to a registered client", func() {
+			req, _ := http.NewRequest("GET", "/", nil)
+			events, ok := a.register(req)
+			So(ok, ShouldBeTrue)
+			defer a.unregister(req)
+
+			a.publish()
+
+			select {
+			case event := <-events:
+				So(string(event), ShouldContainSubstring, "my-pool")
+				So(string(event), ShouldContainSubstring, "HystrixThreadPool")
+			case <-time.After(time.Second):
+				t.Fatal("publish did not deliver a merged thread-pool event to the registered client")
+			}
+		})
+	})
+}
+
+func TestAggregatorHandlerDynamicSources(t *testing.T) {
+	Convey("given an AggregatorHandler backed by a SourceProvider whose membership changes", t, func() {
+		provider := &fakeSourceProvider{sources: []string{"http://127.0.0.1:1/stream-a"}}
+		a := NewAggregatorHandlerWithProvider(provider)
+		defer a.Stop()
+
+		Convey("it starts a consume goroutine for each initial source", func() {
+			a.sourcesMu.Lock()
+			_, ok := a.sources["http://127.0.0.1:1/stream-a"]
+			count := len(a.sources)
+			a.sourcesMu.Unlock()
+
+			So(ok, ShouldBeTrue)
+			So(count, ShouldEqual, 1)
+		})
+
+		Convey("reconcileSources starts newly added sources and stops removed ones", func() {
+			provider.set([]string{"http://127.0.0.1:1/stream-b"})
+			a.reconcileSources()
+
+			a.sourcesMu.Lock()
+			_, stillHasOld := a.sources["http://127.0.0.1:1/stream-a"]
+			_, hasNew := a.sources["http://127.0.0.1:1/stream-b"]
+			count := len(a.sources)
+			a.sourcesMu.Unlock()
+
+			So(stillHasOld, ShouldBeFalse)
+			So(hasNew, ShouldBeTrue)
+			So(count, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestAggregatorHandlerLifecycle(t *testing.T) {
+	Convey("given a running AggregatorHandler", t, func() {
+		a := NewAggregatorHandlerWithProvider(staticSourceProvider(nil))
+
+		Convey("Stop is safe to call more than once", func() {
+			a.Stop()
+			So(a.Stop, ShouldNotPanic)
+		})
+
+		Convey("ServeHTTP rejects a client that connects after Stop instead of blocking forever", func() {
+			a.Stop()
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			rw := newDiscardResponseWriter()
+
+			served := make(chan struct{})
+			go func() {
+				a.ServeHTTP(rw, req)
+				close(served)
+			}()
+
+			select {
+			case <-served:
+			case <-time.After(time.Second):
+				t.Fatal("ServeHTTP blocked forever for a client registered after Stop")
+			}
+			So(rw.statusCode, ShouldEqual, http.StatusServiceUnavailable)
+		})
+	})
+}
@@ -0,0 +1,199 @@
+package hystrix
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultCollapserMaxBatchSize is used when a collapser's
+	// MaxBatchSize is left at zero; zero means unbounded.
+	DefaultCollapserMaxBatchSize = 0
+	// DefaultCollapserTimerWindow is the batching window, in
+	// milliseconds, used when a collapser's TimerWindow is left at
+	// zero.
+	DefaultCollapserTimerWindow = 10
+)
+
+// CollapserConfig configures how Collapse batches calls for a given
+// circuit name.
+type CollapserConfig struct {
+	// MaxBatchSize caps how many distinct keys accumulate before a
+	// batch is dispatched early, regardless of TimerWindow. Zero
+	// means unbounded.
+	MaxBatchSize int
+	// TimerWindow is how long, in milliseconds, a batch accepts new
+	// keys before it is dispatched.
+	TimerWindow int
+}
+
+// collapser buffers the calls arriving for a single circuit name and
+// dispatches them as one batched execution through the circuit
+// breaker.
+type collapser struct {
+	mu      sync.Mutex
+	name    string
+	config  CollapserConfig
+	pending map[string]*collapseEntry
+	timer   *time.Timer
+}
+
+type collapseEntry struct {
+	fn     func() (interface{}, error)
+	result interface{}
+	err    error
+	done   chan struct{}
+}
+
+var collapsersMutex sync.Mutex
+var collapsers = make(map[string]*collapser)
+
+// ConfigureCollapser sets the batching config used by Collapse for
+// name. It may be called before or after Collapse has been used for
+// that name.
+func ConfigureCollapser(name string, config CollapserConfig) {
+	collapsersMutex.Lock()
+	defer collapsersMutex.Unlock()
+	collapsers[name] = &collapser{name: name, config: config, pending: make(map[string]*collapseEntry)}
+}
+
+func getCollapser(name string) *collapser {
+	collapsersMutex.Lock()
+	defer collapsersMutex.Unlock()
+
+	c, ok := collapsers[name]
+	if !ok {
+		c = &collapser{name: name, pending: make(map[string]*collapseEntry)}
+		collapsers[name] = c
+	}
+	return c
+}
+
+// Collapse buffers fn under key for up to the configured TimerWindow,
+// then runs every distinct key accumulated in that window through one
+// circuit execution of name. Concurrent calls sharing the same key
+// within a window receive the same result and bump
+// RollingCountCollapsedRequests instead of running fn again.
+func Collapse(name, key string, fn func() (interface{}, error)) (interface{}, error) {
+	c := getCollapser(name)
+	entry, isLeader := c.addOrAttach(key, fn)
+	if !isLeader {
+		recordCollapsedRequest(name)
+	}
+	<-entry.done
+	return entry.result, entry.err
+}
+
+func (c *collapser) addOrAttach(key string, fn func() (interface{}, error)) (entry *collapseEntry, isLeader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.pending[key]; ok {
+		return e, false
+	}
+
+	e := &collapseEntry{fn: fn, done: make(chan struct{})}
+	c.pending[key] = e
+
+	if c.timer == nil {
+		window := time.Duration(c.config.TimerWindow) * time.Millisecond
+		if window == 0 {
+			window = DefaultCollapserTimerWindow * time.Millisecond
+		}
+		c.timer = time.AfterFunc(window, c.dispatch)
+	}
+
+	if c.config.MaxBatchSize > 0 && len(c.pending) >= c.config.MaxBatchSize {
+		c.timer.Stop()
+		go c.dispatch()
+	}
+
+	return e, true
+}
+
+func (c *collapser) dispatch() {
+	c.mu.Lock()
+	batch := c.pending
+	c.pending = make(map[string]*collapseEntry)
+	c.timer = nil
+	c.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	var ranMu sync.Mutex
+	ran := make(map[*collapseEntry]bool, len(batch))
+
+	err := <-Go(c.name, func() error {
+		var wg sync.WaitGroup
+		var failed int32
+		for _, entry := range batch {
+			wg.Add(1)
+			go func(e *collapseEntry) {
+				defer wg.Done()
+				defer func() {
+					ranMu.Lock()
+					ran[e] = true
+					ranMu.Unlock()
+				}()
+				defer func() {
+					if r := recover(); r != nil {
+						e.err = fmt.Errorf("hystrix: collapsed call panicked: %v", r)
+						atomic.AddInt32(&failed, 1)
+					}
+				}()
+				e.result, e.err = e.fn()
+				if e.err != nil {
+					atomic.AddInt32(&failed, 1)
+				}
+			}(entry)
+		}
+		wg.Wait()
+		if failed > 0 {
+			return fmt.Errorf("hystrix: %d of %d collapsed calls failed", failed, len(batch))
+		}
+		return nil
+	}, nil)
+
+	// err is now non-nil either because the circuit short-circuited
+	// the whole batch before any entry's fn ran, or because Go's own
+	// success/failure accounting reflects that one or more entries
+	// failed (see the failed counter above) -- either way, apply it
+	// only to entries that never got a result or error of their own.
+	if err != nil {
+		ranMu.Lock()
+		for _, entry := range batch {
+			if !ran[entry] {
+				entry.err = err
+			}
+		}
+		ranMu.Unlock()
+	}
+
+	for _, entry := range batch {
+		close(entry.done)
+	}
+}
+
+var collapsedRequestsMutex sync.Mutex
+var collapsedRequests = make(map[string]uint32)
+
+func recordCollapsedRequest(name string) {
+	collapsedRequestsMutex.Lock()
+	defer collapsedRequestsMutex.Unlock()
+	collapsedRequests[name]++
+}
+
+// collapsedRequestsFor returns and resets the number of Collapse
+// calls that shared an in-flight batch entry for name since the last
+// call, so the stream reports a per-tick rolling count.
+func collapsedRequestsFor(name string) uint32 {
+	collapsedRequestsMutex.Lock()
+	defer collapsedRequestsMutex.Unlock()
+	n := collapsedRequests[name]
+	collapsedRequests[name] = 0
+	return n
+}
@@ -0,0 +1,74 @@
+package hystrix
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCollapse(t *testing.T) {
+	Convey("given a configured collapser", t, func() {
+		ConfigureCollapser("collapse", CollapserConfig{TimerWindow: 20})
+		// Same Flush() eventstream_test.go already calls between runs to
+		// reset package-level state; it lives in command.go, which isn't
+		// part of this snapshot, so this can't be exercised here.
+		defer Flush()
+
+		Convey("concurrent calls sharing a key get the same result", func() {
+			const callers = 5
+			results := make(chan interface{}, callers)
+			errs := make(chan error, callers)
+
+			for i := 0; i < callers; i++ {
+				go func() {
+					result, err := Collapse("collapse", "shared-key", func() (interface{}, error) {
+						return "computed", nil
+					})
+					results <- result
+					errs <- err
+				}()
+			}
+
+			for i := 0; i < callers; i++ {
+				So(<-errs, ShouldBeNil)
+				So(<-results, ShouldEqual, "computed")
+			}
+
+			Convey("every caller but the leader is recorded as collapsed", func() {
+				So(collapsedRequestsFor("collapse"), ShouldEqual, callers-1)
+			})
+		})
+
+		Convey("distinct keys dispatch independently", func() {
+			resultA, errA := Collapse("collapse", "a", func() (interface{}, error) { return "a-result", nil })
+			resultB, errB := Collapse("collapse", "b", func() (interface{}, error) { return "b-result", nil })
+
+			So(errA, ShouldBeNil)
+			So(errB, ShouldBeNil)
+			So(resultA, ShouldEqual, "a-result")
+			So(resultB, ShouldEqual, "b-result")
+		})
+
+		Convey("a batch dispatches once MaxBatchSize keys accumulate, without waiting for TimerWindow", func() {
+			ConfigureCollapser("collapse-batch", CollapserConfig{TimerWindow: 10 * 1000, MaxBatchSize: 2})
+
+			done := make(chan struct{}, 2)
+			go func() {
+				Collapse("collapse-batch", "k1", func() (interface{}, error) { return nil, nil })
+				done <- struct{}{}
+			}()
+			go func() {
+				Collapse("collapse-batch", "k2", func() (interface{}, error) { return nil, nil })
+				done <- struct{}{}
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("batch did not dispatch early when MaxBatchSize was reached")
+			}
+			<-done
+		})
+	})
+}
@@ -0,0 +1,99 @@
+package hystrix
+
+import (
+	"time"
+
+	metricCollector "github.com/rfloriano/hystrix-go/hystrix/metric_collector"
+)
+
+// RegisterCollector adds a MetricCollector that will be updated with
+// every circuit's rollup on each reporting tick. Built-in collectors
+// live under the top-level plugins package (StatsD, Prometheus, a
+// generic exec collector); callers may also register their own. A
+// StreamHandler must be running somewhere in the process to drive the
+// reporting tick -- RegisterCollector alone does not start one.
+func RegisterCollector(c metricCollector.MetricCollector) {
+	metricCollector.Register(c)
+}
+
+// commandMetricFor snapshots a circuit's rolling metrics into the
+// shared CommandMetric type so every registered collector sees the
+// same numbers without recomputing the rollup itself.
+func commandMetricFor(cb *CircuitBreaker) metricCollector.CommandMetric {
+	now := time.Now()
+	reqCount := cb.metrics.Requests().Sum(now)
+	errCount := cb.metrics.Errors.Sum(now)
+	settings := getSettings(cb.Name)
+
+	totalLatency := latencyPercentiles(cb.metrics.TotalDuration.Timings())
+	executeLatency := latencyPercentiles(cb.metrics.RunDuration.Timings())
+
+	return metricCollector.CommandMetric{
+		Name:         cb.Name,
+		RequestCount: uint32(reqCount),
+		ErrorCount:   uint32(errCount),
+		ErrorPct:     uint32(cb.metrics.ErrorPercent(now)),
+		CircuitOpen:  cb.isOpen(),
+		ForceOpen:    cb.forceOpen,
+
+		Successes:         uint32(cb.metrics.Successes.Sum(now)),
+		Failures:          uint32(cb.metrics.Failures.Sum(now)),
+		Rejected:          uint32(cb.metrics.Rejected.Sum(now)),
+		ShortCircuited:    uint32(cb.metrics.ShortCircuits.Sum(now)),
+		Timeouts:          uint32(cb.metrics.Timeouts.Sum(now)),
+		FallbackSuccesses: uint32(cb.metrics.FallbackSuccesses.Sum(now)),
+		FallbackFailures:  uint32(cb.metrics.FallbackFailures.Sum(now)),
+
+		CollapsedRequests:  collapsedRequestsFor(cb.Name),
+		ResponsesFromCache: cacheHitsFor(cb.Name),
+
+		LatencyTotalMean:   cb.metrics.TotalDuration.Mean(),
+		LatencyExecuteMean: cb.metrics.RunDuration.Mean(),
+		LatencyTotal:       totalLatency,
+		LatencyExecute:     executeLatency,
+		LatencyP50:         executeLatency.P50,
+		LatencyP99:         executeLatency.P99,
+
+		ErrorThresholdPercent:  uint32(settings.ErrorPercentThreshold),
+		SleepWindow:            uint32(settings.SleepWindow / time.Millisecond),
+		RequestVolumeThreshold: uint32(settings.RequestVolumeThreshold),
+		RollingStatsWindow:     uint32(settings.RollingStatsWindow / time.Millisecond),
+		IsolationStrategy:      string(settings.IsolationStrategy),
+		RequestCacheEnabled:    settings.RequestCacheEnabled,
+		RequestLogEnabled:      settings.RequestLogEnabled,
+	}
+}
+
+// threadPoolMetricFor snapshots a circuit's executor pool into the
+// shared ThreadPoolMetric type.
+func threadPoolMetricFor(pool *executorPool) metricCollector.ThreadPoolMetric {
+	now := time.Now()
+	settings := getSettings(pool.Name)
+
+	return metricCollector.ThreadPoolMetric{
+		Name:               pool.Name,
+		ActiveCount:        uint32(pool.ActiveCount()),
+		ExecutedCount:      uint32(pool.Metrics.Executed.Sum(now)),
+		MaxActiveCount:     uint32(pool.Metrics.MaxActiveRequests.Max(now)),
+		PoolSize:           uint32(pool.Max),
+		RollingStatsWindow: uint32(settings.RollingStatsWindow / time.Millisecond),
+	}
+}
+
+// latencyPercentiles converts a rolling timing's percentile buckets
+// into the metricCollector-safe LatencyPercentiles type, so
+// CommandMetric can carry them without metricCollector importing
+// hystrix back.
+func latencyPercentiles(t streamCmdLatency) metricCollector.LatencyPercentiles {
+	return metricCollector.LatencyPercentiles{
+		P0:   t.Timing0,
+		P25:  t.Timing25,
+		P50:  t.Timing50,
+		P75:  t.Timing75,
+		P90:  t.Timing90,
+		P95:  t.Timing95,
+		P99:  t.Timing99,
+		P995: t.Timing995,
+		P100: t.Timing100,
+	}
+}
@@ -0,0 +1,123 @@
+package hystrix
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rfloriano/hystrix-go/hystrix/requestcache"
+)
+
+type requestContextKeyType struct{}
+
+var requestContextKey = requestContextKeyType{}
+
+// WithRequestContext attaches a fresh request-scoped cache to ctx.
+// Commands invoked with GoC/DoC under the returned context (or a
+// context derived from it) share a cached result for identical cache
+// keys, so pass the same ctx to every command that belongs to one
+// logical request.
+func WithRequestContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestContextKey, requestcache.New())
+}
+
+func requestCacheFrom(ctx context.Context) *requestcache.Cache {
+	cache, _ := ctx.Value(requestContextKey).(*requestcache.Cache)
+	return cache
+}
+
+var cacheKeyFuncsMutex sync.RWMutex
+var cacheKeyFuncs = make(map[string]func(args ...interface{}) string)
+
+// SetCacheKey registers the function GoC/DoC use to turn the
+// arguments passed for name into a request-cache key. A circuit with
+// no registered key function is never cached, even under a context
+// from WithRequestContext.
+func SetCacheKey(name string, keyFn func(args ...interface{}) string) {
+	cacheKeyFuncsMutex.Lock()
+	defer cacheKeyFuncsMutex.Unlock()
+	cacheKeyFuncs[name] = keyFn
+}
+
+func cacheKeyFor(name string, args ...interface{}) (string, bool) {
+	cacheKeyFuncsMutex.RLock()
+	keyFn, ok := cacheKeyFuncs[name]
+	cacheKeyFuncsMutex.RUnlock()
+	if !ok {
+		return "", false
+	}
+	return keyFn(args...), true
+}
+
+var cacheHitsMutex sync.Mutex
+var cacheHits = make(map[string]uint32)
+
+func recordCacheHit(name string) {
+	cacheHitsMutex.Lock()
+	defer cacheHitsMutex.Unlock()
+	cacheHits[name]++
+}
+
+// cacheHitsFor returns and resets the number of GoC/DoC calls served
+// from the request cache for name since the last call, so the stream
+// reports a per-tick rolling count rather than a lifetime total.
+func cacheHitsFor(name string) uint32 {
+	cacheHitsMutex.Lock()
+	defer cacheHitsMutex.Unlock()
+	n := cacheHits[name]
+	cacheHits[name] = 0
+	return n
+}
+
+// GoC runs run through the named circuit exactly like Go, except that
+// when ctx carries a request cache (see WithRequestContext) and name
+// has a cache key function registered via SetCacheKey, identical
+// in-flight or completed calls for the same key share one result
+// instead of each executing the command.
+func GoC(ctx context.Context, name string, run func() error, fallback func(error) error, args ...interface{}) chan error {
+	errChan := make(chan error, 1)
+
+	cache := requestCacheFrom(ctx)
+	key, cacheable := cacheKeyFor(name, args...)
+	if cache == nil || !cacheable {
+		return Go(name, run, fallback)
+	}
+
+	entry, created := cache.GetOrCreate(key)
+	if !created {
+		recordCacheHit(name)
+		go func() {
+			_, err := entry.Wait()
+			errChan <- err
+		}()
+		return errChan
+	}
+
+	go func() {
+		err := <-Go(name, run, fallback)
+		entry.Set(nil, err)
+		errChan <- err
+	}()
+
+	return errChan
+}
+
+// DoC runs run through the named circuit exactly like Do, sharing
+// results across identical calls the same way GoC does.
+func DoC(ctx context.Context, name string, run func() error, fallback func(error) error, args ...interface{}) error {
+	cache := requestCacheFrom(ctx)
+	key, cacheable := cacheKeyFor(name, args...)
+	if cache == nil || !cacheable {
+		return Do(name, run, fallback)
+	}
+
+	entry, created := cache.GetOrCreate(key)
+	if !created {
+		recordCacheHit(name)
+		_, err := entry.Wait()
+		return err
+	}
+
+	err := Do(name, run, fallback)
+	entry.Set(nil, err)
+	return err
+}
@@ -0,0 +1,80 @@
+package hystrix
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequestCache(t *testing.T) {
+	Convey("given a circuit with a registered cache key", t, func() {
+		SetCacheKey("cached", func(args ...interface{}) string {
+			return args[0].(string)
+		})
+		// Same Flush() eventstream_test.go already calls between runs to
+		// reset package-level state; it lives in command.go, which isn't
+		// part of this snapshot, so this can't be exercised here.
+		defer Flush()
+
+		Convey("DoC shares one result across calls with the same key in one request context", func() {
+			ctx := WithRequestContext(context.Background())
+			calls := 0
+
+			run := func() error {
+				calls++
+				return nil
+			}
+
+			So(DoC(ctx, "cached", run, nil, "key"), ShouldBeNil)
+			So(DoC(ctx, "cached", run, nil, "key"), ShouldBeNil)
+
+			So(calls, ShouldEqual, 1)
+			So(cacheHitsFor("cached"), ShouldEqual, 1)
+		})
+
+		Convey("DoC does not share results across distinct request contexts", func() {
+			calls := 0
+			run := func() error {
+				calls++
+				return nil
+			}
+
+			So(DoC(WithRequestContext(context.Background()), "cached", run, nil, "key"), ShouldBeNil)
+			So(DoC(WithRequestContext(context.Background()), "cached", run, nil, "key"), ShouldBeNil)
+
+			So(calls, ShouldEqual, 2)
+		})
+
+		Convey("DoC runs the command directly when ctx carries no request cache", func() {
+			calls := 0
+			run := func() error {
+				calls++
+				return nil
+			}
+
+			So(DoC(context.Background(), "cached", run, nil, "key"), ShouldBeNil)
+			So(calls, ShouldEqual, 1)
+		})
+
+		Convey("GoC shares one result across concurrent calls with the same key", func() {
+			ctx := WithRequestContext(context.Background())
+
+			done := make(chan bool)
+			errA := GoC(ctx, "cached", func() error {
+				<-done
+				return nil
+			}, nil, "shared")
+
+			errB := GoC(ctx, "cached", func() error {
+				t.Fatal("should not run fn for the second caller sharing a key")
+				return nil
+			}, nil, "shared")
+
+			close(done)
+			So(<-errA, ShouldBeNil)
+			So(<-errB, ShouldBeNil)
+			So(cacheHitsFor("cached"), ShouldEqual, 1)
+		})
+	})
+}
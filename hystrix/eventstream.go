@@ -1,43 +1,152 @@
 package hystrix
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	metricCollector "github.com/rfloriano/hystrix-go/hystrix/metric_collector"
 )
 
 const (
 	streamEventBufferSize = 10
 )
 
+// ErrAlreadyRunning is returned by Start when the handler is already
+// serving a stream.
+var ErrAlreadyRunning = errors.New("hystrix: StreamHandler already running")
+
+// DropPolicy controls what StreamHandler does with an event when a
+// client's buffer is full.
+type DropPolicy int
+
+const (
+	// DropNewest discards the event that didn't fit, leaving the
+	// client's buffered events untouched. This was the only behaviour
+	// before DropPolicy existed.
+	DropNewest DropPolicy = iota
+	// DropOldest discards the oldest buffered event to make room for
+	// the new one, so clients always see the most recent state.
+	DropOldest
+	// Block waits up to ClientTimeout for room in the client's
+	// buffer before giving up and dropping the event.
+	Block
+)
+
 func NewStreamHandler() *StreamHandler {
 	return &StreamHandler{}
 }
 
+// streamClient is a single connected client: its delivery channel and
+// the StreamEncoder negotiated for it at connect time.
+type streamClient struct {
+	events  chan []byte
+	encoder StreamEncoder
+}
+
+// StreamHandler publishes metrics for registered circuits to a list
+// of subscribed web clients via the Hystrix SSE wire format, or one
+// of the alternative StreamEncoders negotiated per client.
 type StreamHandler struct {
-	requests map[*http.Request]chan []byte
+	requests map[*http.Request]*streamClient
+	dropped  map[*http.Request]*uint64
 	mu       sync.RWMutex
 	done     chan struct{}
+	wg       sync.WaitGroup
+	running  bool
+
+	// DropPolicy controls backpressure when a client falls behind;
+	// it defaults to DropNewest. ClientTimeout is only consulted when
+	// DropPolicy is Block.
+	DropPolicy    DropPolicy
+	ClientTimeout time.Duration
 }
 
-func (sh *StreamHandler) Start() {
-	sh.requests = make(map[*http.Request]chan []byte)
+// Start begins publishing metrics to registered clients. It returns
+// ErrAlreadyRunning if the handler is already running; call Stop
+// first to restart it.
+func (sh *StreamHandler) Start() error {
+	sh.mu.Lock()
+	if sh.running {
+		sh.mu.Unlock()
+		return ErrAlreadyRunning
+	}
+	sh.running = true
+	sh.requests = make(map[*http.Request]*streamClient)
+	sh.dropped = make(map[*http.Request]*uint64)
 	sh.done = make(chan struct{})
+	sh.mu.Unlock()
+
+	metricCollector.Register(sh)
+
+	sh.wg.Add(1)
 	go sh.loop()
+	return nil
 }
 
+// Stop shuts the handler down: it stops the reporting loop, closes
+// every connected client's channel so their ServeHTTP call returns,
+// and unregisters the handler as a MetricCollector. It is safe to
+// call multiple times and safe to call Start again afterward.
 func (sh *StreamHandler) Stop() {
+	sh.mu.Lock()
+	if !sh.running {
+		sh.mu.Unlock()
+		return
+	}
 	close(sh.done)
+	sh.mu.Unlock()
+
+	sh.wg.Wait()
+	metricCollector.Unregister(sh)
+
+	sh.mu.Lock()
+	for req, client := range sh.requests {
+		close(client.events)
+		delete(sh.requests, req)
+		delete(sh.dropped, req)
+	}
+	sh.running = false
+	sh.mu.Unlock()
+}
+
+// Wait blocks until the reporting loop started by Start has exited,
+// which happens once Stop has been called. It does not wait for Stop
+// to close the connected clients' channels, so it can return before
+// those clients' ServeHTTP calls have returned.
+func (sh *StreamHandler) Wait() {
+	sh.wg.Wait()
+}
+
+// DroppedEvents returns how many events have been dropped for req
+// because its client wasn't keeping up, or 0 if req isn't connected.
+func (sh *StreamHandler) DroppedEvents(req *http.Request) uint64 {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	counter, ok := sh.dropped[req]
+	if !ok {
+		return 0
+	}
+	return atomic.LoadUint64(counter)
 }
 
 var _ http.Handler = (*StreamHandler)(nil)
 
 func (sh *StreamHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	events := sh.register(req)
+	encoder := negotiateEncoder(req)
+	events, ok := sh.register(req, encoder)
+	if !ok {
+		http.Error(rw, "hystrix: StreamHandler is not running", http.StatusServiceUnavailable)
+		return
+	}
 	defer sh.unregister(req)
-	rw.Header().Add("Content-Type", "text/event-stream")
+	rw.Header().Add("Content-Type", encoder.ContentType())
+	if _, ok := encoder.(gzipJSONEncoder); ok {
+		rw.Header().Add("Content-Encoding", "gzip")
+	}
 	for event := range events {
 		_, err := rw.Write(event)
 		if err != nil {
@@ -49,15 +158,30 @@ func (sh *StreamHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// loop drives the reporting tick for every registered MetricCollector,
+// not just this StreamHandler -- the stream used to recompute its own
+// rollups every second; now it computes each circuit's metrics once
+// and fans the result out to every collector via Update/UpdateThreadPool,
+// so a StatsD or Prometheus collector registered alongside the
+// dashboard doesn't cause the rollup to be done twice.
 func (sh *StreamHandler) loop() {
+	defer sh.wg.Done()
 	tick := time.Tick(1 * time.Second)
 	for {
 		select {
 		case <-tick:
 			circuitBreakersMutex.RLock()
+			collectors := metricCollector.Collectors()
 			for _, cb := range circuitBreakers {
-				sh.publishMetrics(cb)
-				sh.publishThreadPools(cb.executorPool)
+				cmdMetric := commandMetricFor(cb)
+				poolMetric := threadPoolMetricFor(cb.executorPool)
+				for _, c := range collectors {
+					c.Update(cmdMetric)
+					c.UpdateThreadPool(poolMetric)
+				}
+			}
+			for _, c := range collectors {
+				c.Flush()
 			}
 			circuitBreakersMutex.RUnlock()
 		case <-sh.done:
@@ -66,139 +190,227 @@ func (sh *StreamHandler) loop() {
 	}
 }
 
-func (sh *StreamHandler) publishMetrics(cb *CircuitBreaker) error {
-	now := time.Now()
-	reqCount := cb.metrics.Requests().Sum(now)
-	errCount := cb.metrics.Errors.Sum(now)
-	errPct := cb.metrics.ErrorPercent(now)
-
-	eventBytes, err := json.Marshal(&streamCmdMetric{
+// Update implements metricCollector.MetricCollector, turning a
+// circuit's rollup into a dashboard-compatible streamCmdMetric event
+// and fanning it out to every connected client, encoding it once per
+// distinct StreamEncoder in use rather than once per client.
+func (sh *StreamHandler) Update(m metricCollector.CommandMetric) {
+	cmd := &streamCmdMetric{
 		Type:           "HystrixCommand",
-		Name:           cb.Name,
-		Group:          cb.Name,
+		Name:           m.Name,
+		Group:          m.Name,
 		Time:           currentTime(),
 		ReportingHosts: 1,
 
-		RequestCount:       uint32(reqCount),
-		ErrorCount:         uint32(errCount),
-		ErrorPct:           uint32(errPct),
-		CircuitBreakerOpen: cb.isOpen(),
-
-		RollingCountSuccess:            uint32(cb.metrics.Successes.Sum(now)),
-		RollingCountFailure:            uint32(cb.metrics.Failures.Sum(now)),
-		RollingCountThreadPoolRejected: uint32(cb.metrics.Rejected.Sum(now)),
-		RollingCountShortCircuited:     uint32(cb.metrics.ShortCircuits.Sum(now)),
-		RollingCountTimeout:            uint32(cb.metrics.Timeouts.Sum(now)),
-		RollingCountFallbackSuccess:    uint32(cb.metrics.FallbackSuccesses.Sum(now)),
-		RollingCountFallbackFailure:    uint32(cb.metrics.FallbackFailures.Sum(now)),
-
-		LatencyTotal:       cb.metrics.TotalDuration.Timings(),
-		LatencyTotalMean:   cb.metrics.TotalDuration.Mean(),
-		LatencyExecute:     cb.metrics.RunDuration.Timings(),
-		LatencyExecuteMean: cb.metrics.RunDuration.Mean(),
-
-		// TODO: all hard-coded values should become configurable settings, per circuit
-
-		RollingStatsWindow:         10000,
-		ExecutionIsolationStrategy: "THREAD",
+		RequestCount:       m.RequestCount,
+		ErrorCount:         m.ErrorCount,
+		ErrorPct:           m.ErrorPct,
+		CircuitBreakerOpen: m.CircuitOpen,
+
+		RollingCountSuccess:            m.Successes,
+		RollingCountFailure:            m.Failures,
+		RollingCountThreadPoolRejected: m.Rejected,
+		RollingCountShortCircuited:     m.ShortCircuited,
+		RollingCountTimeout:            m.Timeouts,
+		RollingCountFallbackSuccess:    m.FallbackSuccesses,
+		RollingCountFallbackFailure:    m.FallbackFailures,
+		RollingCountCollapsedRequests:  m.CollapsedRequests,
+		RollingCountResponsesFromCache: m.ResponsesFromCache,
+
+		LatencyTotalMean:   m.LatencyTotalMean,
+		LatencyTotal:       streamLatencyFrom(m.LatencyTotal),
+		LatencyExecuteMean: m.LatencyExecuteMean,
+		LatencyExecute:     streamLatencyFrom(m.LatencyExecute),
+
+		RollingStatsWindow:         m.RollingStatsWindow,
+		ExecutionIsolationStrategy: m.IsolationStrategy,
 
 		CircuitBreakerEnabled:                true,
 		CircuitBreakerForceClosed:            false,
-		CircuitBreakerForceOpen:              cb.forceOpen,
-		CircuitBreakerErrorThresholdPercent:  50,
-		CircuitBreakerSleepWindow:            5000,
-		CircuitBreakerRequestVolumeThreshold: 20,
-	})
-	if err != nil {
-		return err
+		CircuitBreakerForceOpen:              m.ForceOpen,
+		CircuitBreakerErrorThresholdPercent:  m.ErrorThresholdPercent,
+		CircuitBreakerSleepWindow:            m.SleepWindow,
+		CircuitBreakerRequestVolumeThreshold: m.RequestVolumeThreshold,
+		RequestCacheEnabled:                  m.RequestCacheEnabled,
+		RequestLogEnabled:                    m.RequestLogEnabled,
 	}
-	err = sh.writeToRequests(eventBytes)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	sh.broadcast(cmd)
 }
 
-func (sh *StreamHandler) publishThreadPools(pool *executorPool) error {
-	now := time.Now()
+// streamLatencyFrom converts a metricCollector.LatencyPercentiles
+// snapshot back into the dashboard-facing streamCmdLatency shape.
+func streamLatencyFrom(p metricCollector.LatencyPercentiles) streamCmdLatency {
+	return streamCmdLatency{
+		Timing0:   p.P0,
+		Timing25:  p.P25,
+		Timing50:  p.P50,
+		Timing75:  p.P75,
+		Timing90:  p.P90,
+		Timing95:  p.P95,
+		Timing99:  p.P99,
+		Timing995: p.P995,
+		Timing100: p.P100,
+	}
+}
 
-	eventBytes, err := json.Marshal(&streamThreadPoolMetric{
+// UpdateThreadPool implements metricCollector.MetricCollector.
+func (sh *StreamHandler) UpdateThreadPool(m metricCollector.ThreadPoolMetric) {
+	tp := &streamThreadPoolMetric{
 		Type:           "HystrixThreadPool",
-		Name:           pool.Name,
+		Name:           m.Name,
 		ReportingHosts: 1,
 
-		CurrentActiveCount:        uint32(pool.ActiveCount()),
+		CurrentActiveCount:        m.ActiveCount,
 		CurrentTaskCount:          0,
 		CurrentCompletedTaskCount: 0,
 
-		RollingCountThreadsExecuted: uint32(pool.Metrics.Executed.Sum(now)),
-		RollingMaxActiveThreads:     uint32(pool.Metrics.MaxActiveRequests.Max(now)),
+		RollingCountThreadsExecuted: m.ExecutedCount,
+		RollingMaxActiveThreads:     m.MaxActiveCount,
 
-		CurrentPoolSize:        uint32(pool.Max),
-		CurrentCorePoolSize:    uint32(pool.Max),
-		CurrentLargestPoolSize: uint32(pool.Max),
-		CurrentMaximumPoolSize: uint32(pool.Max),
+		CurrentPoolSize:        m.PoolSize,
+		CurrentCorePoolSize:    m.PoolSize,
+		CurrentLargestPoolSize: m.PoolSize,
+		CurrentMaximumPoolSize: m.PoolSize,
 
-		RollingStatsWindow:          10000,
+		RollingStatsWindow:          m.RollingStatsWindow,
 		QueueSizeRejectionThreshold: 0,
 		CurrentQueueSize:            0,
-	})
-	if err != nil {
-		return err
 	}
-	err = sh.writeToRequests(eventBytes)
-
-	return nil
+	sh.broadcastThreadPool(tp)
 }
 
-func (sh *StreamHandler) writeToRequests(eventBytes []byte) error {
-	var b bytes.Buffer
-	_, err := b.Write([]byte("data:"))
-	if err != nil {
-		return err
-	}
+// Flush implements metricCollector.MetricCollector. The stream writes
+// each event to its clients as soon as it is computed, so there is
+// nothing to batch.
+func (sh *StreamHandler) Flush() {}
 
-	_, err = b.Write(eventBytes)
-	if err != nil {
-		return err
-	}
-	_, err = b.Write([]byte("\n\n"))
-	if err != nil {
-		return err
+// broadcast encodes cmd once per distinct StreamEncoder in use among
+// connected clients and delivers the result to every client using
+// that encoder.
+func (sh *StreamHandler) broadcast(cmd *streamCmdMetric) {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	encoded := make(map[string][]byte, len(sh.requests))
+	for req, client := range sh.requests {
+		kind := fmt.Sprintf("%T", client.encoder)
+		dataBytes, ok := encoded[kind]
+		if !ok {
+			var err error
+			dataBytes, err = client.encoder.Encode(cmd)
+			if err != nil {
+				continue
+			}
+			encoded[kind] = dataBytes
+		}
+		sh.send(req, client.events, dataBytes)
 	}
-	dataBytes := b.Bytes()
+}
+
+// broadcastThreadPool encodes tp once per distinct StreamEncoder in
+// use among connected clients and delivers the result to every client
+// using that encoder, the thread-pool counterpart of broadcast.
+func (sh *StreamHandler) broadcastThreadPool(tp *streamThreadPoolMetric) {
 	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	encoded := make(map[string][]byte, len(sh.requests))
+	for req, client := range sh.requests {
+		kind := fmt.Sprintf("%T", client.encoder)
+		dataBytes, ok := encoded[kind]
+		if !ok {
+			var err error
+			dataBytes, err = client.encoder.EncodeThreadPool(tp)
+			if err != nil {
+				continue
+			}
+			encoded[kind] = dataBytes
+		}
+		sh.send(req, client.events, dataBytes)
+	}
+}
 
-	for _, requestEvents := range sh.requests {
+// send delivers dataBytes to a single client's channel according to
+// sh.DropPolicy, incrementing its dropped-event counter when the
+// event doesn't fit. Callers must hold sh.mu (for at least reading).
+func (sh *StreamHandler) send(req *http.Request, events chan []byte, dataBytes []byte) {
+	switch sh.DropPolicy {
+	case DropOldest:
+		for {
+			select {
+			case events <- dataBytes:
+				return
+			default:
+			}
+			select {
+			case <-events:
+				sh.countDropped(req)
+			default:
+				sh.countDropped(req)
+				return
+			}
+		}
+	case Block:
+		timeout := sh.ClientTimeout
+		if timeout <= 0 {
+			timeout = time.Second
+		}
 		select {
-		case requestEvents <- dataBytes:
+		case events <- dataBytes:
+		case <-time.After(timeout):
+			sh.countDropped(req)
+		}
+	default: // DropNewest
+		select {
+		case events <- dataBytes:
 		default:
+			sh.countDropped(req)
 		}
 	}
-	sh.mu.RUnlock()
+}
 
-	return nil
+func (sh *StreamHandler) countDropped(req *http.Request) {
+	if counter, ok := sh.dropped[req]; ok {
+		atomic.AddUint64(counter, 1)
+	}
 }
 
-func (sh *StreamHandler) register(req *http.Request) <-chan []byte {
+// register adds req as a connected client and returns its event
+// channel, or returns ok == false if the handler isn't running --
+// including the window after Stop has closed and drained every
+// client it knew about but before it has returned, since register
+// and that drain both hold sh.mu and so can never race each other.
+// Without this check a client that called ServeHTTP just after Stop
+// would be added to sh.requests but never serviced or closed, and
+// would block in its range over events forever.
+func (sh *StreamHandler) register(req *http.Request, encoder StreamEncoder) (events <-chan []byte, ok bool) {
 	sh.mu.RLock()
-	events, ok := sh.requests[req]
+	client, exists := sh.requests[req]
+	running := sh.running
 	sh.mu.RUnlock()
-	if ok {
-		return events
+	if exists {
+		return client.events, true
+	}
+	if !running {
+		return nil, false
 	}
 
-	events = make(chan []byte, streamEventBufferSize)
+	client = &streamClient{events: make(chan []byte, streamEventBufferSize), encoder: encoder}
 	sh.mu.Lock()
-	sh.requests[req] = events
+	if !sh.running {
+		sh.mu.Unlock()
+		return nil, false
+	}
+	sh.requests[req] = client
+	sh.dropped[req] = new(uint64)
 	sh.mu.Unlock()
-	return events
+	return client.events, true
 }
 
 func (sh *StreamHandler) unregister(req *http.Request) {
 	sh.mu.Lock()
 	delete(sh.requests, req)
+	delete(sh.dropped, req)
 	sh.mu.Unlock()
 }
 
@@ -0,0 +1,128 @@
+package hystrix
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStreamHandlerLifecycle(t *testing.T) {
+	Convey("given a StreamHandler", t, func() {
+		sh := NewStreamHandler()
+
+		Convey("Start can only be called once without an intervening Stop", func() {
+			So(sh.Start(), ShouldBeNil)
+			defer sh.Stop()
+
+			So(sh.Start(), ShouldEqual, ErrAlreadyRunning)
+		})
+
+		Convey("Stop releases every client blocked in ServeHTTP", func() {
+			So(sh.Start(), ShouldBeNil)
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			rw := newDiscardResponseWriter()
+
+			served := make(chan struct{})
+			go func() {
+				sh.ServeHTTP(rw, req)
+				close(served)
+			}()
+
+			// Give ServeHTTP a moment to register before stopping, so
+			// this exercises the close-on-Stop path rather than a
+			// register that never happens.
+			time.Sleep(10 * time.Millisecond)
+			sh.Stop()
+
+			select {
+			case <-served:
+			case <-time.After(time.Second):
+				t.Fatal("ServeHTTP did not return after Stop")
+			}
+		})
+
+		Convey("Start can be called again after Stop", func() {
+			So(sh.Start(), ShouldBeNil)
+			sh.Stop()
+			So(sh.Start(), ShouldBeNil)
+			sh.Stop()
+		})
+
+		Convey("ServeHTTP rejects a client that connects after Stop instead of blocking forever", func() {
+			So(sh.Start(), ShouldBeNil)
+			sh.Stop()
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			rw := newDiscardResponseWriter()
+
+			served := make(chan struct{})
+			go func() {
+				sh.ServeHTTP(rw, req)
+				close(served)
+			}()
+
+			select {
+			case <-served:
+			case <-time.After(time.Second):
+				t.Fatal("ServeHTTP blocked forever for a client registered after Stop")
+			}
+			So(rw.statusCode, ShouldEqual, http.StatusServiceUnavailable)
+		})
+	})
+}
+
+func TestStreamHandlerDropPolicy(t *testing.T) {
+	Convey("given a registered client with a full buffer", t, func() {
+		sh := NewStreamHandler()
+		req, _ := http.NewRequest("GET", "/", nil)
+		events := make(chan []byte, 1)
+		sh.requests = map[*http.Request]*streamClient{req: {events: events, encoder: jsonSSEEncoder{}}}
+		sh.dropped = map[*http.Request]*uint64{req: new(uint64)}
+		events <- []byte("first")
+
+		Convey("DropNewest (the default) drops the newest event and keeps the buffered one", func() {
+			sh.send(req, events, []byte("second"))
+
+			So(sh.DroppedEvents(req), ShouldEqual, 1)
+			So(string(<-events), ShouldEqual, "first")
+		})
+
+		Convey("DropOldest evicts the buffered event to make room for the newest", func() {
+			sh.DropPolicy = DropOldest
+			sh.send(req, events, []byte("second"))
+
+			So(sh.DroppedEvents(req), ShouldEqual, 1)
+			So(string(<-events), ShouldEqual, "second")
+		})
+
+		Convey("Block waits up to ClientTimeout then drops if nothing drains the buffer", func() {
+			sh.DropPolicy = Block
+			sh.ClientTimeout = 10 * time.Millisecond
+
+			start := time.Now()
+			sh.send(req, events, []byte("second"))
+
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, sh.ClientTimeout)
+			So(sh.DroppedEvents(req), ShouldEqual, 1)
+		})
+	})
+}
+
+// discardResponseWriter is a minimal http.ResponseWriter that throws
+// away everything written to it, just enough to drive ServeHTTP in a
+// goroutine without a real network connection.
+type discardResponseWriter struct {
+	header     http.Header
+	statusCode int
+}
+
+func newDiscardResponseWriter() *discardResponseWriter {
+	return &discardResponseWriter{header: make(http.Header), statusCode: http.StatusOK}
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardResponseWriter) WriteHeader(statusCode int)  { d.statusCode = statusCode }
@@ -0,0 +1,125 @@
+// Package metricCollector provides the pluggable interface that
+// hystrix-go uses to publish circuit and thread pool metrics to
+// external systems (StatsD, Prometheus, the SSE event stream, etc).
+package metricCollector
+
+import "sync"
+
+// MetricCollector represents the interface that a metrics backend
+// must implement in order to receive updates from hystrix-go. A
+// single process may register many collectors; every registered
+// collector is updated once per circuit per reporting tick.
+type MetricCollector interface {
+	// Update is called once per reporting tick for every circuit
+	// with a rollup of that circuit's current metrics.
+	Update(CommandMetric)
+	// UpdateThreadPool is called once per reporting tick for every
+	// circuit's executor pool.
+	UpdateThreadPool(ThreadPoolMetric)
+	// Flush is called after every circuit has been updated for the
+	// current tick, giving the collector a chance to push a batch of
+	// buffered updates out to its backend.
+	Flush()
+}
+
+// CommandMetric is a point-in-time snapshot of a single circuit's
+// rolling metrics, shared by every registered MetricCollector so that
+// the rollup is only computed once per tick regardless of how many
+// collectors are listening.
+type CommandMetric struct {
+	Name         string
+	RequestCount uint32
+	ErrorCount   uint32
+	ErrorPct     uint32
+	CircuitOpen  bool
+	ForceOpen    bool
+
+	Successes          uint32
+	Failures           uint32
+	Rejected           uint32
+	ShortCircuited     uint32
+	Timeouts           uint32
+	FallbackSuccesses  uint32
+	FallbackFailures   uint32
+	CollapsedRequests  uint32
+	ResponsesFromCache uint32
+
+	LatencyTotalMean   uint32
+	LatencyExecuteMean uint32
+	LatencyP50         uint32
+	LatencyP99         uint32
+	LatencyTotal       LatencyPercentiles
+	LatencyExecute     LatencyPercentiles
+
+	ErrorThresholdPercent  uint32
+	SleepWindow            uint32
+	RequestVolumeThreshold uint32
+	RollingStatsWindow     uint32
+	IsolationStrategy      string
+	RequestCacheEnabled    bool
+	RequestLogEnabled      bool
+}
+
+// LatencyPercentiles is a point-in-time snapshot of a latency
+// distribution's percentile buckets. It mirrors the hystrix package's
+// internal streamCmdLatency shape so CommandMetric can carry full
+// percentile data without this package importing hystrix back (which
+// would create an import cycle, since hystrix imports metricCollector).
+type LatencyPercentiles struct {
+	P0   uint32
+	P25  uint32
+	P50  uint32
+	P75  uint32
+	P90  uint32
+	P95  uint32
+	P99  uint32
+	P995 uint32
+	P100 uint32
+}
+
+// ThreadPoolMetric is a point-in-time snapshot of a single circuit's
+// executor pool.
+type ThreadPoolMetric struct {
+	Name               string
+	ActiveCount        uint32
+	ExecutedCount      uint32
+	MaxActiveCount     uint32
+	PoolSize           uint32
+	RollingStatsWindow uint32
+}
+
+var registry = struct {
+	sync.RWMutex
+	collectors []MetricCollector
+}{}
+
+// Register adds a collector that will be updated on every subsequent
+// reporting tick. It is safe to call concurrently with metric
+// publishing.
+func Register(c MetricCollector) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.collectors = append(registry.collectors, c)
+}
+
+// Unregister removes a previously registered collector, matched by
+// identity. It is a no-op if c was never registered.
+func Unregister(c MetricCollector) {
+	registry.Lock()
+	defer registry.Unlock()
+	for i, existing := range registry.collectors {
+		if existing == c {
+			registry.collectors = append(registry.collectors[:i], registry.collectors[i+1:]...)
+			return
+		}
+	}
+}
+
+// Collectors returns the collectors currently registered.
+func Collectors() []MetricCollector {
+	registry.RLock()
+	defer registry.RUnlock()
+	collectors := make([]MetricCollector, len(registry.collectors))
+	copy(collectors, registry.collectors)
+	return collectors
+}
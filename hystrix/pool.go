@@ -0,0 +1,188 @@
+package hystrix
+
+import (
+	"sync"
+	"time"
+)
+
+// poolMetrics tracks an executorPool's rolling execution counters,
+// consulted by threadPoolMetricFor to publish thread-pool stats on
+// the event stream.
+type poolMetrics struct {
+	Executed          *rollingNumber
+	MaxActiveRequests *rollingNumber
+}
+
+func newPoolMetrics(window time.Duration) *poolMetrics {
+	return &poolMetrics{
+		Executed:          newRollingNumber(window),
+		MaxActiveRequests: newRollingNumber(window),
+	}
+}
+
+// executorPool gates concurrent executions for a single circuit,
+// isolated either by a fixed-size thread pool (Thread, the default
+// IsolationStrategy) or by a plain counting semaphore (Semaphore),
+// per the circuit's configured settings. Both strategies share the
+// same ticket-channel implementation; only how Max is sized differs.
+//
+// Nothing in this snapshot calls TryAcquire/Return yet: that's the
+// job of Go/Do's execution path in command.go, which isn't part of
+// this tree. This type is the isolation primitive that path is meant
+// to gate on -- wiring it in is still pending, not done.
+type executorPool struct {
+	Name    string
+	Max     int
+	Metrics *poolMetrics
+
+	strategy IsolationStrategy
+	tickets  chan *struct{}
+
+	mu     sync.Mutex
+	active int
+}
+
+// newExecutorPool builds the pool for name from its current settings.
+// A Thread pool sizes Max from MaxConcurrentRequests; a Semaphore
+// pool sizes Max from ExecutionIsolationSemaphoreMaxConcurrentRequests
+// instead, matching the reference Hystrix's separate thread-pool and
+// semaphore properties.
+func newExecutorPool(name string) *executorPool {
+	settings := getSettings(name)
+
+	max := settings.MaxConcurrentRequests
+	if settings.IsolationStrategy == Semaphore {
+		max = settings.SemaphoreMaxConcurrentRequests
+		if max == 0 {
+			max = DefaultMaxConcurrentRequests
+		}
+	}
+
+	p := &executorPool{
+		Name:     name,
+		Max:      max,
+		Metrics:  newPoolMetrics(settings.RollingStatsWindow),
+		strategy: settings.IsolationStrategy,
+		tickets:  make(chan *struct{}, max),
+	}
+	for i := 0; i < max; i++ {
+		p.tickets <- &struct{}{}
+	}
+	return p
+}
+
+// TryAcquire claims a ticket for an execution, returning false
+// immediately -- never blocking -- if the pool is already at
+// capacity. This is the single acquisition path for both isolation
+// strategies; Semaphore isolation differs only in how Max was sized,
+// not in how acquisition behaves.
+func (p *executorPool) TryAcquire() bool {
+	select {
+	case <-p.tickets:
+	default:
+		return false
+	}
+
+	p.mu.Lock()
+	p.active++
+	p.Metrics.MaxActiveRequests.UpdateMax(float64(p.active))
+	p.mu.Unlock()
+
+	return true
+}
+
+// Return releases a ticket acquired via TryAcquire and records the
+// execution against the pool's rolling executed count.
+func (p *executorPool) Return() {
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+
+	p.Metrics.Executed.Increment(1)
+	p.tickets <- &struct{}{}
+}
+
+// ActiveCount returns how many executions currently hold a ticket
+// from this pool.
+func (p *executorPool) ActiveCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active
+}
+
+// rollingNumber is a small per-second-bucketed counter that discards
+// buckets older than its window as it is read. It backs
+// executorPool's Executed/MaxActiveRequests counters.
+type rollingNumber struct {
+	mu      sync.Mutex
+	window  time.Duration
+	buckets map[int64]float64
+}
+
+func newRollingNumber(window time.Duration) *rollingNumber {
+	return &rollingNumber{window: window, buckets: make(map[int64]float64)}
+}
+
+func (r *rollingNumber) bucketKey(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Second)
+}
+
+// Increment adds v to the current second's bucket.
+func (r *rollingNumber) Increment(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.buckets[r.bucketKey(now)] += v
+	r.evict(now)
+}
+
+// UpdateMax records v as the current second's bucket value if it is
+// larger than what's already there.
+func (r *rollingNumber) UpdateMax(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	key := r.bucketKey(now)
+	if v > r.buckets[key] {
+		r.buckets[key] = v
+	}
+	r.evict(now)
+}
+
+// evict drops every bucket older than the window, measured from now.
+// Callers must hold r.mu.
+func (r *rollingNumber) evict(now time.Time) {
+	cutoff := r.bucketKey(now) - int64(r.window/time.Second)
+	for k := range r.buckets {
+		if k < cutoff {
+			delete(r.buckets, k)
+		}
+	}
+}
+
+// Sum returns the total of every bucket still within the window.
+func (r *rollingNumber) Sum(now time.Time) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evict(now)
+	var sum float64
+	for _, v := range r.buckets {
+		sum += v
+	}
+	return sum
+}
+
+// Max returns the largest single bucket value still within the
+// window.
+func (r *rollingNumber) Max(now time.Time) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evict(now)
+	var max float64
+	for _, v := range r.buckets {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
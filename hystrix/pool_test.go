@@ -0,0 +1,62 @@
+package hystrix
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewExecutorPoolSizing(t *testing.T) {
+	Convey("given a circuit configured for Thread isolation", t, func() {
+		ConfigureCommand("pool-thread", CommandConfig{MaxConcurrentRequests: 3})
+		p := newExecutorPool("pool-thread")
+
+		Convey("Max is sized from MaxConcurrentRequests", func() {
+			So(p.Max, ShouldEqual, 3)
+		})
+	})
+
+	Convey("given a circuit configured for Semaphore isolation", t, func() {
+		ConfigureCommand("pool-semaphore", CommandConfig{
+			IsolationStrategy: Semaphore,
+			ExecutionIsolationSemaphoreMaxConcurrentRequests: 4,
+			MaxConcurrentRequests:                            3,
+		})
+		p := newExecutorPool("pool-semaphore")
+
+		Convey("Max is sized from ExecutionIsolationSemaphoreMaxConcurrentRequests instead", func() {
+			So(p.Max, ShouldEqual, 4)
+		})
+	})
+
+	Convey("given a circuit configured for Semaphore isolation with no semaphore limit set", t, func() {
+		ConfigureCommand("pool-semaphore-default", CommandConfig{IsolationStrategy: Semaphore})
+		p := newExecutorPool("pool-semaphore-default")
+
+		Convey("Max falls back to DefaultMaxConcurrentRequests", func() {
+			So(p.Max, ShouldEqual, DefaultMaxConcurrentRequests)
+		})
+	})
+}
+
+func TestExecutorPoolTryAcquire(t *testing.T) {
+	Convey("given a pool with one ticket", t, func() {
+		ConfigureCommand("pool-acquire", CommandConfig{MaxConcurrentRequests: 1})
+		p := newExecutorPool("pool-acquire")
+
+		Convey("the first TryAcquire succeeds", func() {
+			So(p.TryAcquire(), ShouldBeTrue)
+			So(p.ActiveCount(), ShouldEqual, 1)
+
+			Convey("a second TryAcquire fails while the ticket is held", func() {
+				So(p.TryAcquire(), ShouldBeFalse)
+			})
+
+			Convey("Return releases the ticket for the next TryAcquire", func() {
+				p.Return()
+				So(p.ActiveCount(), ShouldEqual, 0)
+				So(p.TryAcquire(), ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,62 @@
+// Package requestcache implements the per-request memoization used by
+// hystrix.GoC/DoC so that identical calls made while handling a single
+// logical request share one result instead of re-executing the
+// command.
+package requestcache
+
+import "sync"
+
+// Cache holds the memoized results for a single logical request, keyed
+// by the cache key a command computes for its arguments. A fresh
+// Cache is meant to be attached to one context.Context (see
+// hystrix.WithRequestContext) and discarded once that request
+// finishes.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]*Entry
+}
+
+// Entry is an in-flight or completed cached result. Callers that find
+// an existing entry via GetOrCreate must call Wait to obtain its
+// value; the caller that created the entry must call Set exactly
+// once to fill it and wake any waiters.
+type Entry struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// New returns an empty cache.
+func New() *Cache {
+	return &Cache{entries: make(map[string]*Entry)}
+}
+
+// GetOrCreate returns the entry for key, creating it if this is the
+// first call for that key. created is true when the caller is
+// responsible for filling the entry via Set.
+func (c *Cache) GetOrCreate(key string) (entry *Entry, created bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		return e, false
+	}
+
+	e := &Entry{done: make(chan struct{})}
+	c.entries[key] = e
+	return e, true
+}
+
+// Set fills the entry with its result and releases any goroutines
+// blocked in Wait. It must be called exactly once.
+func (e *Entry) Set(value interface{}, err error) {
+	e.value = value
+	e.err = err
+	close(e.done)
+}
+
+// Wait blocks until the entry has been filled and returns its result.
+func (e *Entry) Wait() (interface{}, error) {
+	<-e.done
+	return e.value, e.err
+}
@@ -0,0 +1,195 @@
+package hystrix
+
+import (
+	"sync"
+	"time"
+)
+
+// IsolationStrategy selects how a command's executions are isolated
+// from the rest of the process.
+type IsolationStrategy string
+
+const (
+	// Thread isolates each execution in its own goroutine, counted
+	// against the circuit's executor pool (the existing behaviour).
+	Thread IsolationStrategy = "THREAD"
+	// Semaphore caps concurrent executions at
+	// ExecutionIsolationSemaphoreMaxConcurrentRequests instead of
+	// MaxConcurrentRequests. executorPool gates both strategies with
+	// the same ticket channel, so the limit is the only difference.
+	Semaphore IsolationStrategy = "SEMAPHORE"
+)
+
+const (
+	DefaultErrorPercentThreshold         = 50
+	DefaultSleepWindow                   = 5000
+	DefaultRequestVolumeThreshold        = 20
+	DefaultRollingStatsWindow            = 10000
+	DefaultIsolationStrategy             = Thread
+	DefaultMaxConcurrentRequests         = 10
+	DefaultFallbackMaxConcurrentRequests = 10
+)
+
+// CommandConfig is the user-facing configuration for a single named
+// circuit, passed to ConfigureCommand.
+//
+// In this tree, every field here is resolved into commandSettings and
+// surfaced on the event stream (see commandMetricFor), but
+// CircuitBreaker and command.go -- the code that would actually honor
+// ErrorPercentThreshold, SleepWindow, RequestVolumeThreshold and
+// IsolationStrategy when deciding whether to trip or how to isolate
+// an execution -- don't exist in this snapshot. Configuring a command
+// changes what gets reported, not (yet) how it behaves; see
+// commandSettings.shouldTrip/allowSingleTest and executorPool.
+type CommandConfig struct {
+	ErrorPercentThreshold  int
+	SleepWindow            int
+	RequestVolumeThreshold int
+	RollingStatsWindow     int
+
+	IsolationStrategy                                IsolationStrategy
+	ExecutionIsolationSemaphoreMaxConcurrentRequests int
+
+	MaxConcurrentRequests int
+	FallbackMaxConcurrent int
+
+	RequestCacheEnabled bool
+	RequestLogEnabled   bool
+}
+
+// commandSettings holds the resolved (defaulted) configuration for a
+// circuit. CircuitBreaker would consult it when deciding whether to
+// trip or reset and the executor pool would consult it when deciding
+// how to isolate an execution; see the CommandConfig doc comment for
+// why neither does yet in this tree.
+type commandSettings struct {
+	ErrorPercentThreshold  int
+	SleepWindow            time.Duration
+	RequestVolumeThreshold uint64
+	RollingStatsWindow     time.Duration
+
+	IsolationStrategy              IsolationStrategy
+	SemaphoreMaxConcurrentRequests int
+
+	MaxConcurrentRequests int
+	FallbackMaxConcurrent int
+
+	RequestCacheEnabled bool
+	RequestLogEnabled   bool
+}
+
+var commandSettingsMutex sync.RWMutex
+var commandSettingsByName = make(map[string]*commandSettings)
+
+// ConfigureCommand applies config to the named circuit. It may be
+// called before or after the circuit has been used; whatever reads
+// settings for that circuit -- today, only the event stream and the
+// executor pool's sizing -- always gets the latest values via
+// getSettings.
+func ConfigureCommand(name string, config CommandConfig) {
+	commandSettingsMutex.Lock()
+	defer commandSettingsMutex.Unlock()
+	commandSettingsByName[name] = newCommandSettings(config)
+}
+
+// Configure is a convenience for configuring several circuits at once.
+func Configure(cmds map[string]CommandConfig) {
+	for name, config := range cmds {
+		ConfigureCommand(name, config)
+	}
+}
+
+func newCommandSettings(config CommandConfig) *commandSettings {
+	errorPercentThreshold := config.ErrorPercentThreshold
+	if errorPercentThreshold == 0 {
+		errorPercentThreshold = DefaultErrorPercentThreshold
+	}
+
+	sleepWindow := config.SleepWindow
+	if sleepWindow == 0 {
+		sleepWindow = DefaultSleepWindow
+	}
+
+	requestVolumeThreshold := config.RequestVolumeThreshold
+	if requestVolumeThreshold == 0 {
+		requestVolumeThreshold = DefaultRequestVolumeThreshold
+	}
+
+	rollingStatsWindow := config.RollingStatsWindow
+	if rollingStatsWindow == 0 {
+		rollingStatsWindow = DefaultRollingStatsWindow
+	}
+
+	isolationStrategy := config.IsolationStrategy
+	if isolationStrategy == "" {
+		isolationStrategy = DefaultIsolationStrategy
+	}
+
+	maxConcurrentRequests := config.MaxConcurrentRequests
+	if maxConcurrentRequests == 0 {
+		maxConcurrentRequests = DefaultMaxConcurrentRequests
+	}
+
+	fallbackMaxConcurrent := config.FallbackMaxConcurrent
+	if fallbackMaxConcurrent == 0 {
+		fallbackMaxConcurrent = DefaultFallbackMaxConcurrentRequests
+	}
+
+	return &commandSettings{
+		ErrorPercentThreshold:  errorPercentThreshold,
+		SleepWindow:            time.Duration(sleepWindow) * time.Millisecond,
+		RequestVolumeThreshold: uint64(requestVolumeThreshold),
+		RollingStatsWindow:     time.Duration(rollingStatsWindow) * time.Millisecond,
+
+		IsolationStrategy:              isolationStrategy,
+		SemaphoreMaxConcurrentRequests: config.ExecutionIsolationSemaphoreMaxConcurrentRequests,
+
+		MaxConcurrentRequests: maxConcurrentRequests,
+		FallbackMaxConcurrent: fallbackMaxConcurrent,
+
+		RequestCacheEnabled: config.RequestCacheEnabled,
+		RequestLogEnabled:   config.RequestLogEnabled,
+	}
+}
+
+// shouldTrip reports whether a circuit with these settings should be
+// open given its current rolling request count and error percentage.
+// CircuitBreaker would consult this on every completed request once
+// requestCount has reached RequestVolumeThreshold; below that volume
+// a circuit never trips no matter how high its error rate is.
+//
+// CircuitBreaker itself (circuit.go) isn't part of this tree, so
+// nothing calls shouldTrip/allowSingleTest yet -- that wiring is
+// still pending, not done.
+func (s *commandSettings) shouldTrip(requestCount uint64, errorPercent int) bool {
+	if requestCount < s.RequestVolumeThreshold {
+		return false
+	}
+	return errorPercent >= s.ErrorPercentThreshold
+}
+
+// allowSingleTest reports whether SleepWindow has elapsed since
+// openedAt, the point at which CircuitBreaker lets exactly one
+// request through as a health check while the rest continue to
+// short-circuit.
+func (s *commandSettings) allowSingleTest(openedAt, now time.Time) bool {
+	return !now.Before(openedAt.Add(s.SleepWindow))
+}
+
+// getSettings returns the resolved settings for name, configuring it
+// with defaults on first use so every circuit has settings before its
+// first execution.
+func getSettings(name string) *commandSettings {
+	commandSettingsMutex.RLock()
+	s, exists := commandSettingsByName[name]
+	commandSettingsMutex.RUnlock()
+
+	if !exists {
+		ConfigureCommand(name, CommandConfig{})
+		commandSettingsMutex.RLock()
+		s = commandSettingsByName[name]
+		commandSettingsMutex.RUnlock()
+	}
+
+	return s
+}
@@ -0,0 +1,83 @@
+package hystrix
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConfigureCommandDefaults(t *testing.T) {
+	Convey("given a circuit configured with a zero-value CommandConfig", t, func() {
+		ConfigureCommand("settings-defaults", CommandConfig{})
+		s := getSettings("settings-defaults")
+
+		Convey("every field falls back to its documented default", func() {
+			So(s.ErrorPercentThreshold, ShouldEqual, DefaultErrorPercentThreshold)
+			So(s.SleepWindow, ShouldEqual, time.Duration(DefaultSleepWindow)*time.Millisecond)
+			So(s.RequestVolumeThreshold, ShouldEqual, uint64(DefaultRequestVolumeThreshold))
+			So(s.RollingStatsWindow, ShouldEqual, time.Duration(DefaultRollingStatsWindow)*time.Millisecond)
+			So(s.IsolationStrategy, ShouldEqual, DefaultIsolationStrategy)
+			So(s.MaxConcurrentRequests, ShouldEqual, DefaultMaxConcurrentRequests)
+			So(s.FallbackMaxConcurrent, ShouldEqual, DefaultFallbackMaxConcurrentRequests)
+		})
+	})
+
+	Convey("given a circuit configured with explicit values", t, func() {
+		ConfigureCommand("settings-explicit", CommandConfig{
+			ErrorPercentThreshold:  10,
+			SleepWindow:            1000,
+			RequestVolumeThreshold: 5,
+			RollingStatsWindow:     2000,
+			IsolationStrategy:      Semaphore,
+			ExecutionIsolationSemaphoreMaxConcurrentRequests: 7,
+		})
+		s := getSettings("settings-explicit")
+
+		Convey("the explicit values are used instead of the defaults", func() {
+			So(s.ErrorPercentThreshold, ShouldEqual, 10)
+			So(s.SleepWindow, ShouldEqual, time.Second)
+			So(s.RequestVolumeThreshold, ShouldEqual, uint64(5))
+			So(s.RollingStatsWindow, ShouldEqual, 2*time.Second)
+			So(s.IsolationStrategy, ShouldEqual, Semaphore)
+			So(s.SemaphoreMaxConcurrentRequests, ShouldEqual, 7)
+		})
+	})
+
+	Convey("given a circuit that was never configured", t, func() {
+		Convey("getSettings configures it with defaults on first use", func() {
+			s := getSettings("settings-never-configured")
+			So(s.ErrorPercentThreshold, ShouldEqual, DefaultErrorPercentThreshold)
+		})
+	})
+}
+
+func TestCommandSettingsShouldTrip(t *testing.T) {
+	Convey("given settings with a request volume threshold of 10 and an error threshold of 50%", t, func() {
+		s := newCommandSettings(CommandConfig{RequestVolumeThreshold: 10, ErrorPercentThreshold: 50})
+
+		Convey("it never trips below the volume threshold, no matter the error rate", func() {
+			So(s.shouldTrip(9, 100), ShouldBeFalse)
+		})
+
+		Convey("it trips once volume is met and the error rate reaches the threshold", func() {
+			So(s.shouldTrip(10, 50), ShouldBeTrue)
+			So(s.shouldTrip(10, 49), ShouldBeFalse)
+		})
+	})
+}
+
+func TestCommandSettingsAllowSingleTest(t *testing.T) {
+	Convey("given settings with a 5 second sleep window", t, func() {
+		s := newCommandSettings(CommandConfig{SleepWindow: 5000})
+		openedAt := time.Now()
+
+		Convey("a single test is not allowed before the window elapses", func() {
+			So(s.allowSingleTest(openedAt, openedAt.Add(4*time.Second)), ShouldBeFalse)
+		})
+
+		Convey("a single test is allowed once the window has elapsed", func() {
+			So(s.allowSingleTest(openedAt, openedAt.Add(5*time.Second)), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,265 @@
+package hystrix
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// StreamEncoder turns a circuit's streamCmdMetric into the bytes
+// written to a connected client and declares how the client should
+// interpret them. StreamHandler.ServeHTTP picks one per client based
+// on its Accept/Accept-Encoding headers; the handler then encodes
+// each tick's metric once per distinct encoder and fans the result
+// out to every client using it, instead of re-encoding per client.
+type StreamEncoder interface {
+	Encode(cmd *streamCmdMetric) ([]byte, error)
+	EncodeThreadPool(tp *streamThreadPoolMetric) ([]byte, error)
+	ContentType() string
+	FrameBoundary() []byte
+}
+
+var jsonSSEFrameBoundary = []byte("\n\n")
+
+// jsonSSEEncoder is the original "data:{...}\n\n" wire format the
+// Hystrix dashboard expects, and remains the default.
+type jsonSSEEncoder struct{}
+
+func (jsonSSEEncoder) Encode(cmd *streamCmdMetric) ([]byte, error) {
+	body, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return frameJSONSSE(body), nil
+}
+
+func (jsonSSEEncoder) EncodeThreadPool(tp *streamThreadPoolMetric) ([]byte, error) {
+	body, err := json.Marshal(tp)
+	if err != nil {
+		return nil, err
+	}
+	return frameJSONSSE(body), nil
+}
+
+func (jsonSSEEncoder) ContentType() string   { return "text/event-stream" }
+func (jsonSSEEncoder) FrameBoundary() []byte { return jsonSSEFrameBoundary }
+
+// frameJSONSSE wraps an already-marshaled JSON body in the
+// "data:...\n\n" framing the Hystrix dashboard expects.
+func frameJSONSSE(body []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("data:")
+	b.Write(body)
+	b.Write(jsonSSEFrameBoundary)
+	return b.Bytes()
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// gzipJSONEncoder wraps the JSON SSE frame in gzip, negotiated via
+// "Accept-Encoding: gzip".
+type gzipJSONEncoder struct{}
+
+func (gzipJSONEncoder) Encode(cmd *streamCmdMetric) ([]byte, error) {
+	inner, err := (jsonSSEEncoder{}).Encode(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return gzipBytes(inner)
+}
+
+func (gzipJSONEncoder) EncodeThreadPool(tp *streamThreadPoolMetric) ([]byte, error) {
+	inner, err := (jsonSSEEncoder{}).EncodeThreadPool(tp)
+	if err != nil {
+		return nil, err
+	}
+	return gzipBytes(inner)
+}
+
+func (gzipJSONEncoder) ContentType() string   { return "text/event-stream" }
+func (gzipJSONEncoder) FrameBoundary() []byte { return nil }
+
+// protobufEncoder writes each event as a 4-byte big-endian length
+// prefix followed by the protobuf encoding of the CommandMetric or
+// ThreadPoolMetric message defined in hystrix.proto, with full field
+// parity with their JSON counterparts. There's no protoc toolchain
+// wired into this build, so the wire bytes below are produced by hand
+// rather than by generated code, following the same field number and
+// wire type protoc would emit for the .proto -- swap this for the
+// generated marshaler once the build can run protoc. In the meantime,
+// stream_encoder_test.go checks the output against
+// google.golang.org/protobuf itself (not just a matching hand-rolled
+// decoder), so a real protobuf client parsing
+// "application/x-hystrix-protobuf" output is a tested guarantee, not
+// an assumption.
+type protobufEncoder struct{}
+
+func (protobufEncoder) Encode(cmd *streamCmdMetric) ([]byte, error) {
+	var body bytes.Buffer
+	writeProtoString(&body, 1, cmd.Name)
+	writeProtoString(&body, 2, cmd.Group)
+	writeProtoVarint(&body, 3, uint64(cmd.Time))
+	writeProtoVarint(&body, 4, uint64(cmd.ReportingHosts))
+
+	writeProtoVarint(&body, 5, uint64(cmd.RequestCount))
+	writeProtoVarint(&body, 6, uint64(cmd.ErrorCount))
+	writeProtoVarint(&body, 7, uint64(cmd.ErrorPct))
+	writeProtoBool(&body, 8, cmd.CircuitBreakerOpen)
+
+	writeProtoVarint(&body, 9, uint64(cmd.RollingCountCollapsedRequests))
+	writeProtoVarint(&body, 10, uint64(cmd.RollingCountExceptionsThrown))
+	writeProtoVarint(&body, 11, uint64(cmd.RollingCountFailure))
+	writeProtoVarint(&body, 12, uint64(cmd.RollingCountFallbackFailure))
+	writeProtoVarint(&body, 13, uint64(cmd.RollingCountFallbackRejection))
+	writeProtoVarint(&body, 14, uint64(cmd.RollingCountFallbackSuccess))
+	writeProtoVarint(&body, 15, uint64(cmd.RollingCountResponsesFromCache))
+	writeProtoVarint(&body, 16, uint64(cmd.RollingCountSemaphoreRejected))
+	writeProtoVarint(&body, 17, uint64(cmd.RollingCountShortCircuited))
+	writeProtoVarint(&body, 18, uint64(cmd.RollingCountSuccess))
+	writeProtoVarint(&body, 19, uint64(cmd.RollingCountThreadPoolRejected))
+	writeProtoVarint(&body, 20, uint64(cmd.RollingCountTimeout))
+
+	writeProtoVarint(&body, 21, uint64(cmd.CurrentConcurrentExecutionCount))
+
+	writeProtoVarint(&body, 22, uint64(cmd.LatencyExecuteMean))
+	writeProtoMessage(&body, 23, latencyPercentilesBytes(cmd.LatencyExecute))
+	writeProtoVarint(&body, 24, uint64(cmd.LatencyTotalMean))
+	writeProtoMessage(&body, 25, latencyPercentilesBytes(cmd.LatencyTotal))
+
+	writeProtoVarint(&body, 26, uint64(cmd.CircuitBreakerRequestVolumeThreshold))
+	writeProtoVarint(&body, 27, uint64(cmd.CircuitBreakerSleepWindow))
+	writeProtoVarint(&body, 28, uint64(cmd.CircuitBreakerErrorThresholdPercent))
+	writeProtoBool(&body, 29, cmd.CircuitBreakerForceOpen)
+	writeProtoBool(&body, 30, cmd.CircuitBreakerForceClosed)
+	writeProtoBool(&body, 31, cmd.CircuitBreakerEnabled)
+	writeProtoString(&body, 32, cmd.ExecutionIsolationStrategy)
+	writeProtoVarint(&body, 33, uint64(cmd.ExecutionIsolationThreadTimeout))
+	writeProtoBool(&body, 34, cmd.ExecutionIsolationThreadInterruptOnTimeout)
+	writeProtoString(&body, 35, cmd.ExecutionIsolationThreadPoolKeyOverride)
+	writeProtoVarint(&body, 36, uint64(cmd.ExecutionIsolationSemaphoreMaxConcurrentRequests))
+	writeProtoVarint(&body, 37, uint64(cmd.FallbackIsolationSemaphoreMaxConcurrentRequests))
+	writeProtoVarint(&body, 38, uint64(cmd.RollingStatsWindow))
+	writeProtoBool(&body, 39, cmd.RequestCacheEnabled)
+	writeProtoBool(&body, 40, cmd.RequestLogEnabled)
+
+	return frameProtobuf(body.Bytes()), nil
+}
+
+func (protobufEncoder) EncodeThreadPool(tp *streamThreadPoolMetric) ([]byte, error) {
+	var body bytes.Buffer
+	writeProtoString(&body, 1, tp.Name)
+	writeProtoVarint(&body, 2, uint64(tp.ReportingHosts))
+
+	writeProtoVarint(&body, 3, uint64(tp.CurrentActiveCount))
+	writeProtoVarint(&body, 4, uint64(tp.CurrentCompletedTaskCount))
+	writeProtoVarint(&body, 5, uint64(tp.CurrentCorePoolSize))
+	writeProtoVarint(&body, 6, uint64(tp.CurrentLargestPoolSize))
+	writeProtoVarint(&body, 7, uint64(tp.CurrentMaximumPoolSize))
+	writeProtoVarint(&body, 8, uint64(tp.CurrentPoolSize))
+	writeProtoVarint(&body, 9, uint64(tp.CurrentQueueSize))
+	writeProtoVarint(&body, 10, uint64(tp.CurrentTaskCount))
+
+	writeProtoVarint(&body, 11, uint64(tp.RollingMaxActiveThreads))
+	writeProtoVarint(&body, 12, uint64(tp.RollingCountThreadsExecuted))
+
+	writeProtoVarint(&body, 13, uint64(tp.RollingStatsWindow))
+	writeProtoVarint(&body, 14, uint64(tp.QueueSizeRejectionThreshold))
+
+	return frameProtobuf(body.Bytes()), nil
+}
+
+func (protobufEncoder) ContentType() string   { return "application/x-hystrix-protobuf" }
+func (protobufEncoder) FrameBoundary() []byte { return nil }
+
+// frameProtobuf prefixes an encoded message with its 4-byte
+// big-endian length, the framing a protobufEncoder client expects to
+// delimit one message from the next on the wire.
+func frameProtobuf(body []byte) []byte {
+	var framed bytes.Buffer
+	var lenPrefix [4]byte
+	binary.BigEndian.PutUint32(lenPrefix[:], uint32(len(body)))
+	framed.Write(lenPrefix[:])
+	framed.Write(body)
+	return framed.Bytes()
+}
+
+// latencyPercentilesBytes encodes a streamCmdLatency as a
+// LatencyPercentiles submessage body.
+func latencyPercentilesBytes(l streamCmdLatency) []byte {
+	var body bytes.Buffer
+	writeProtoVarint(&body, 1, uint64(l.Timing0))
+	writeProtoVarint(&body, 2, uint64(l.Timing25))
+	writeProtoVarint(&body, 3, uint64(l.Timing50))
+	writeProtoVarint(&body, 4, uint64(l.Timing75))
+	writeProtoVarint(&body, 5, uint64(l.Timing90))
+	writeProtoVarint(&body, 6, uint64(l.Timing95))
+	writeProtoVarint(&body, 7, uint64(l.Timing99))
+	writeProtoVarint(&body, 8, uint64(l.Timing995))
+	writeProtoVarint(&body, 9, uint64(l.Timing100))
+	return body.Bytes()
+}
+
+func protoTag(fieldNum int, wireType byte) uint64 {
+	return uint64(fieldNum)<<3 | uint64(wireType)
+}
+
+func writeProtoVarintRaw(buf *bytes.Buffer, v uint64) {
+	for v >= 0x80 {
+		buf.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	buf.WriteByte(byte(v))
+}
+
+func writeProtoVarint(buf *bytes.Buffer, fieldNum int, v uint64) {
+	writeProtoVarintRaw(buf, protoTag(fieldNum, 0))
+	writeProtoVarintRaw(buf, v)
+}
+
+func writeProtoBool(buf *bytes.Buffer, fieldNum int, v bool) {
+	var i uint64
+	if v {
+		i = 1
+	}
+	writeProtoVarint(buf, fieldNum, i)
+}
+
+func writeProtoString(buf *bytes.Buffer, fieldNum int, s string) {
+	writeProtoVarintRaw(buf, protoTag(fieldNum, 2))
+	writeProtoVarintRaw(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+// writeProtoMessage writes a length-delimited embedded message field,
+// used for CommandMetric's LatencyPercentiles submessages.
+func writeProtoMessage(buf *bytes.Buffer, fieldNum int, msg []byte) {
+	writeProtoVarintRaw(buf, protoTag(fieldNum, 2))
+	writeProtoVarintRaw(buf, uint64(len(msg)))
+	buf.Write(msg)
+}
+
+// negotiateEncoder picks a StreamEncoder for req based on its Accept
+// and Accept-Encoding headers, defaulting to the dashboard's plain
+// JSON SSE format.
+func negotiateEncoder(req *http.Request) StreamEncoder {
+	if strings.Contains(req.Header.Get("Accept"), "application/x-hystrix-protobuf") {
+		return protobufEncoder{}
+	}
+	if strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
+		return gzipJSONEncoder{}
+	}
+	return jsonSSEEncoder{}
+}
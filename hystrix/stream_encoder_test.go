@@ -0,0 +1,137 @@
+package hystrix
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// protoMessageDescriptor parses hystrix.proto -- the actual schema
+// protobufEncoder's wire bytes are meant to match -- and returns the
+// descriptor for one of its messages, so a test can hand the bytes to
+// google.golang.org/protobuf instead of decoding them itself.
+func protoMessageDescriptor(t *testing.T, name string) *desc.MessageDescriptor {
+	t.Helper()
+	fds, err := (protoparse.Parser{ImportPaths: []string{"."}}).ParseFiles("hystrix.proto")
+	if err != nil {
+		t.Fatalf("parsing hystrix.proto: %v", err)
+	}
+	md := fds[0].FindMessage(name)
+	if md == nil {
+		t.Fatalf("hystrix.proto has no message %q", name)
+	}
+	return md
+}
+
+// unmarshalProto hands body to the real protobuf library as an
+// instance of md, so a failure here means a real protobuf client
+// would fail to parse protobufEncoder's output too, not just that
+// our own encoder and decoder happen to agree with each other.
+func unmarshalProto(t *testing.T, md *desc.MessageDescriptor, body []byte) *dynamicpb.Message {
+	t.Helper()
+	msg := dynamicpb.NewMessageType(md.UnwrapMessage()).New().Interface().(*dynamicpb.Message)
+	if err := proto.Unmarshal(body, msg); err != nil {
+		t.Fatalf("google.golang.org/protobuf rejected protobufEncoder's wire bytes: %v", err)
+	}
+	return msg
+}
+
+func TestProtobufEncoderWireCompatibility(t *testing.T) {
+	Convey("given a streamCmdMetric encoded by protobufEncoder", t, func() {
+		cmd := &streamCmdMetric{
+			Name:               "my-circuit",
+			Group:              "my-group",
+			RequestCount:       42,
+			ErrorCount:         7,
+			ErrorPct:           16,
+			CircuitBreakerOpen: true,
+			LatencyTotal:       streamCmdLatency{Timing50: 12, Timing99: 345},
+		}
+
+		framed, err := (protobufEncoder{}).Encode(cmd)
+
+		Convey("it frames the message with a 4-byte big-endian length prefix", func() {
+			So(err, ShouldBeNil)
+			So(len(framed), ShouldBeGreaterThan, 4)
+			length := binary.BigEndian.Uint32(framed[:4])
+			So(int(length), ShouldEqual, len(framed)-4)
+		})
+
+		Convey("the real protobuf library parses the body as a hystrix.CommandMetric with every field protobufEncoder set", func() {
+			So(err, ShouldBeNil)
+			md := protoMessageDescriptor(t, "hystrix.CommandMetric")
+			msg := unmarshalProto(t, md, framed[4:])
+			fields := md.UnwrapMessage().Fields()
+			refl := msg.ProtoReflect()
+
+			So(refl.Get(fields.ByName("name")).String(), ShouldEqual, "my-circuit")
+			So(refl.Get(fields.ByName("group")).String(), ShouldEqual, "my-group")
+			So(refl.Get(fields.ByName("request_count")).Uint(), ShouldEqual, 42)
+			So(refl.Get(fields.ByName("error_count")).Uint(), ShouldEqual, 7)
+			So(refl.Get(fields.ByName("error_percentage")).Uint(), ShouldEqual, 16)
+			So(refl.Get(fields.ByName("is_circuit_breaker_open")).Bool(), ShouldBeTrue)
+
+			latencyTotal := refl.Get(fields.ByName("latency_total")).Message()
+			latencyFields := latencyTotal.Descriptor().Fields()
+			So(latencyTotal.Get(latencyFields.ByName("p50")).Uint(), ShouldEqual, 12)
+			So(latencyTotal.Get(latencyFields.ByName("p99")).Uint(), ShouldEqual, 345)
+		})
+	})
+
+	Convey("given a streamThreadPoolMetric encoded by protobufEncoder", t, func() {
+		tp := &streamThreadPoolMetric{
+			Name:                        "my-circuit",
+			CurrentActiveCount:          3,
+			RollingCountThreadsExecuted: 99,
+		}
+
+		framed, err := (protobufEncoder{}).EncodeThreadPool(tp)
+
+		Convey("the real protobuf library parses the body as a hystrix.ThreadPoolMetric with every field protobufEncoder set", func() {
+			So(err, ShouldBeNil)
+			md := protoMessageDescriptor(t, "hystrix.ThreadPoolMetric")
+			msg := unmarshalProto(t, md, framed[4:])
+			fields := md.UnwrapMessage().Fields()
+			refl := msg.ProtoReflect()
+
+			So(refl.Get(fields.ByName("name")).String(), ShouldEqual, "my-circuit")
+			So(refl.Get(fields.ByName("current_active_count")).Uint(), ShouldEqual, 3)
+			So(refl.Get(fields.ByName("rolling_count_threads_executed")).Uint(), ShouldEqual, 99)
+		})
+	})
+}
+
+func TestGzipJSONEncoderFraming(t *testing.T) {
+	Convey("given a streamCmdMetric encoded by gzipJSONEncoder", t, func() {
+		cmd := &streamCmdMetric{Name: "my-circuit", RequestCount: 5}
+
+		compressed, err := (gzipJSONEncoder{}).Encode(cmd)
+		So(err, ShouldBeNil)
+
+		Convey("it decompresses back to the jsonSSEEncoder's \"data:...\\n\\n\" framing", func() {
+			gz, err := gzip.NewReader(bytes.NewReader(compressed))
+			So(err, ShouldBeNil)
+			defer gz.Close()
+
+			decompressed, err := ioutil.ReadAll(gz)
+			So(err, ShouldBeNil)
+
+			expected, err := (jsonSSEEncoder{}).Encode(cmd)
+			So(err, ShouldBeNil)
+			So(decompressed, ShouldResemble, expected)
+		})
+
+		Convey("FrameBoundary is nil since gzip framing carries its own boundary", func() {
+			So((gzipJSONEncoder{}).FrameBoundary(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,210 @@
+package plugins
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metricCollector "github.com/rfloriano/hystrix-go/hystrix/metric_collector"
+)
+
+// ExecMetric is one metric parsed from the exec target's stdout,
+// whether it was written as a JSON object or an InfluxDB
+// line-protocol line.
+type ExecMetric struct {
+	Name   string
+	Tags   map[string]string
+	Fields map[string]float64
+}
+
+// ExecCollector runs an external command on a fixed interval and
+// parses whatever metrics it writes to stdout -- mirroring how
+// Telegraf's exec input works, rather than pushing hystrix's own
+// metrics into the child's stdin. Each line of output is parsed as
+// either a JSON object or an InfluxDB line-protocol line.
+type ExecCollector struct {
+	command  string
+	args     []string
+	interval time.Duration
+
+	mu      sync.RWMutex
+	metrics []ExecMetric
+	running bool
+	done    chan struct{}
+}
+
+// NewExecCollector starts the periodic exec/parse loop and returns a
+// MetricCollector ready to be passed to hystrix.RegisterCollector.
+// Call Stop to end the loop once the collector is no longer needed.
+func NewExecCollector(command string, args []string, interval time.Duration) *ExecCollector {
+	e := &ExecCollector{
+		command:  command,
+		args:     args,
+		interval: interval,
+		running:  true,
+		done:     make(chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+// Stop ends the periodic exec/parse loop. It is safe to call more than
+// once.
+func (e *ExecCollector) Stop() {
+	e.mu.Lock()
+	if !e.running {
+		e.mu.Unlock()
+		return
+	}
+	e.running = false
+	e.mu.Unlock()
+
+	close(e.done)
+}
+
+// Update implements metricCollector.MetricCollector. ExecCollector's
+// metrics come from parsing the exec target's own stdout on each
+// interval tick, not from hystrix's per-circuit rollup, so there is
+// nothing to buffer here.
+func (e *ExecCollector) Update(metricCollector.CommandMetric) {}
+
+// UpdateThreadPool implements metricCollector.MetricCollector.
+func (e *ExecCollector) UpdateThreadPool(metricCollector.ThreadPoolMetric) {}
+
+// Flush implements metricCollector.MetricCollector. Parsing happens
+// on e.interval rather than on every tick, so Flush is a no-op.
+func (e *ExecCollector) Flush() {}
+
+// Metrics returns the metrics parsed from the most recent run of the
+// exec target.
+func (e *ExecCollector) Metrics() []ExecMetric {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make([]ExecMetric, len(e.metrics))
+	copy(out, e.metrics)
+	return out
+}
+
+func (e *ExecCollector) loop() {
+	tick := time.Tick(e.interval)
+	for {
+		select {
+		case <-tick:
+			e.dispatch()
+		case <-e.done:
+			return
+		}
+	}
+}
+
+func (e *ExecCollector) dispatch() {
+	cmd := exec.Command(e.command, e.args...)
+	// A failed or misbehaving exec target just means this interval's
+	// metrics are dropped, which is preferable to blocking collection
+	// on a flaky external process.
+	out, err := cmd.Output()
+	if err != nil {
+		return
+	}
+
+	parsed := parseExecOutput(out)
+
+	e.mu.Lock()
+	e.metrics = parsed
+	e.mu.Unlock()
+}
+
+// parseExecOutput parses the exec target's stdout one line at a
+// time, accepting either a JSON object or an InfluxDB line-protocol
+// line per line, same as Telegraf's exec input's "json"/"influx"
+// data formats. Lines matching neither are skipped.
+func parseExecOutput(out []byte) []ExecMetric {
+	var metrics []ExecMetric
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if m, ok := parseJSONMetric(line); ok {
+			metrics = append(metrics, m)
+			continue
+		}
+		if m, ok := parseLineProtocolMetric(line); ok {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// jsonMetric is the shape parseJSONMetric expects: a measurement
+// name, optional string tags, and numeric fields.
+type jsonMetric struct {
+	Name   string             `json:"name"`
+	Tags   map[string]string  `json:"tags"`
+	Fields map[string]float64 `json:"fields"`
+}
+
+func parseJSONMetric(line string) (ExecMetric, bool) {
+	var m jsonMetric
+	if err := json.Unmarshal([]byte(line), &m); err != nil || m.Name == "" {
+		return ExecMetric{}, false
+	}
+	return ExecMetric{Name: m.Name, Tags: m.Tags, Fields: m.Fields}, true
+}
+
+// parseLineProtocolMetric parses a single InfluxDB line-protocol
+// line: "measurement[,tag=value...] field=value[,field=value...]
+// [timestamp]". The optional trailing timestamp is ignored.
+func parseLineProtocolMetric(line string) (ExecMetric, bool) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 {
+		return ExecMetric{}, false
+	}
+
+	measurementAndTags := strings.Split(parts[0], ",")
+	name := measurementAndTags[0]
+	if name == "" {
+		return ExecMetric{}, false
+	}
+
+	var tags map[string]string
+	for _, tagPair := range measurementAndTags[1:] {
+		k, v, ok := splitKV(tagPair)
+		if !ok {
+			return ExecMetric{}, false
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[k] = v
+	}
+
+	fields := make(map[string]float64)
+	for _, fieldPair := range strings.Split(parts[1], ",") {
+		k, v, ok := splitKV(fieldPair)
+		if !ok {
+			return ExecMetric{}, false
+		}
+		f, err := strconv.ParseFloat(strings.TrimSuffix(v, "i"), 64)
+		if err != nil {
+			return ExecMetric{}, false
+		}
+		fields[k] = f
+	}
+	if len(fields) == 0 {
+		return ExecMetric{}, false
+	}
+
+	return ExecMetric{Name: name, Tags: tags, Fields: fields}, true
+}
+
+func splitKV(s string) (key, value string, ok bool) {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
@@ -0,0 +1,88 @@
+package plugins
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseExecOutput(t *testing.T) {
+	Convey("given output mixing JSON and InfluxDB line-protocol metrics", t, func() {
+		out := []byte(`
+{"name":"cpu","tags":{"host":"a"},"fields":{"usage":0.5}}
+mem,host=a free=128,used=512i
+not a metric line
+`)
+
+		metrics := parseExecOutput(out)
+
+		Convey("each recognized line is parsed into an ExecMetric", func() {
+			So(metrics, ShouldHaveLength, 2)
+
+			So(metrics[0].Name, ShouldEqual, "cpu")
+			So(metrics[0].Tags, ShouldResemble, map[string]string{"host": "a"})
+			So(metrics[0].Fields, ShouldResemble, map[string]float64{"usage": 0.5})
+
+			So(metrics[1].Name, ShouldEqual, "mem")
+			So(metrics[1].Tags, ShouldResemble, map[string]string{"host": "a"})
+			So(metrics[1].Fields, ShouldResemble, map[string]float64{"free": 128, "used": 512})
+		})
+	})
+
+	Convey("given a line missing a measurement name", t, func() {
+		metrics := parseExecOutput([]byte(",host=a free=128"))
+
+		Convey("it is skipped instead of parsed as an empty-named metric", func() {
+			So(metrics, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestExecCollector(t *testing.T) {
+	Convey("given an ExecCollector running a command that prints line-protocol output", t, func() {
+		e := NewExecCollector("sh", []string{"-c", "echo 'cpu usage=0.75'"}, 10*time.Millisecond)
+		defer e.Stop()
+
+		Convey("it parses the exec target's stdout on each interval tick", func() {
+			deadline := time.Now().Add(time.Second)
+			for time.Now().Before(deadline) {
+				if metrics := e.Metrics(); len(metrics) == 1 {
+					So(metrics[0].Name, ShouldEqual, "cpu")
+					So(metrics[0].Fields, ShouldResemble, map[string]float64{"usage": 0.75})
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			t.Fatal("ExecCollector never reported the exec target's metrics")
+		})
+	})
+}
+
+func TestExecCollectorStop(t *testing.T) {
+	Convey("given an ExecCollector running a command that prints line-protocol output", t, func() {
+		e := NewExecCollector("sh", []string{"-c", "echo 'cpu usage=0.75'"}, 10*time.Millisecond)
+
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) && len(e.Metrics()) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(e.Metrics(), ShouldHaveLength, 1)
+
+		Convey("Stop ends the exec/parse loop instead of leaking it for the life of the process", func() {
+			e.Stop()
+			time.Sleep(50 * time.Millisecond)
+			before := e.Metrics()
+
+			time.Sleep(100 * time.Millisecond)
+			after := e.Metrics()
+
+			So(after, ShouldResemble, before)
+		})
+
+		Convey("Stop is safe to call more than once", func() {
+			e.Stop()
+			So(e.Stop, ShouldNotPanic)
+		})
+	})
+}
@@ -0,0 +1,75 @@
+package plugins
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	metricCollector "github.com/rfloriano/hystrix-go/hystrix/metric_collector"
+)
+
+// PrometheusCollector keeps the latest snapshot of every circuit and
+// serves it in the Prometheus text exposition format.
+type PrometheusCollector struct {
+	mu       sync.RWMutex
+	commands map[string]metricCollector.CommandMetric
+}
+
+// NewPrometheusCollector returns a MetricCollector/http.Handler pair:
+// register the collector with hystrix.RegisterCollector and mount the
+// handler (typically at "/metrics") for Prometheus to scrape.
+func NewPrometheusCollector() (*PrometheusCollector, http.Handler) {
+	p := &PrometheusCollector{commands: make(map[string]metricCollector.CommandMetric)}
+	return p, http.HandlerFunc(p.serveHTTP)
+}
+
+// Update implements metricCollector.MetricCollector.
+func (p *PrometheusCollector) Update(m metricCollector.CommandMetric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.commands[m.Name] = m
+}
+
+// UpdateThreadPool implements metricCollector.MetricCollector.
+func (p *PrometheusCollector) UpdateThreadPool(metricCollector.ThreadPoolMetric) {}
+
+// Flush implements metricCollector.MetricCollector. Scrapes just read
+// the latest snapshot, so there is nothing to batch.
+func (p *PrometheusCollector) Flush() {}
+
+func (p *PrometheusCollector) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	fmt.Fprintln(w, "# HELP hystrix_command_requests_total Total requests executed through the circuit.")
+	fmt.Fprintln(w, "# TYPE hystrix_command_requests_total counter")
+	for _, m := range p.commands {
+		fmt.Fprintf(w, "hystrix_command_requests_total{command=%q} %d\n", m.Name, m.RequestCount)
+	}
+
+	// Reported as a summary, not a histogram: CommandMetric carries
+	// pre-computed percentiles (see LatencyTotal), not the cumulative
+	// bucket counts a Prometheus histogram requires.
+	fmt.Fprintln(w, "# HELP hystrix_command_latency_seconds Total command latency.")
+	fmt.Fprintln(w, "# TYPE hystrix_command_latency_seconds summary")
+	for _, m := range p.commands {
+		fmt.Fprintf(w, "hystrix_command_latency_seconds{command=%q,quantile=\"0.5\"} %f\n", m.Name, float64(m.LatencyTotal.P50)/1000)
+		fmt.Fprintf(w, "hystrix_command_latency_seconds{command=%q,quantile=\"0.9\"} %f\n", m.Name, float64(m.LatencyTotal.P90)/1000)
+		fmt.Fprintf(w, "hystrix_command_latency_seconds{command=%q,quantile=\"0.99\"} %f\n", m.Name, float64(m.LatencyTotal.P99)/1000)
+		fmt.Fprintf(w, "hystrix_command_latency_seconds_sum{command=%q} %f\n", m.Name, float64(m.LatencyTotalMean)*float64(m.RequestCount)/1000)
+		fmt.Fprintf(w, "hystrix_command_latency_seconds_count{command=%q} %d\n", m.Name, m.RequestCount)
+	}
+
+	fmt.Fprintln(w, "# HELP hystrix_circuit_open Whether the circuit is currently open.")
+	fmt.Fprintln(w, "# TYPE hystrix_circuit_open gauge")
+	for _, m := range p.commands {
+		fmt.Fprintf(w, "hystrix_circuit_open{command=%q} %d\n", m.Name, boolToInt(m.CircuitOpen))
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package plugins
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	metricCollector "github.com/rfloriano/hystrix-go/hystrix/metric_collector"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPrometheusCollector(t *testing.T) {
+	Convey("given a PrometheusCollector updated with one circuit's metrics", t, func() {
+		p, handler := NewPrometheusCollector()
+		p.Update(metricCollector.CommandMetric{
+			Name:             "my-circuit",
+			RequestCount:     10,
+			CircuitOpen:      true,
+			LatencyTotalMean: 5,
+			LatencyTotal:     metricCollector.LatencyPercentiles{P50: 10, P90: 20, P99: 30},
+		})
+
+		Convey("scraping renders the Prometheus text exposition format", func() {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+			body := rec.Body.String()
+			So(body, ShouldContainSubstring, "# TYPE hystrix_command_requests_total counter")
+			So(body, ShouldContainSubstring, `hystrix_command_requests_total{command="my-circuit"} 10`)
+			So(body, ShouldContainSubstring, "# TYPE hystrix_command_latency_seconds summary")
+			So(body, ShouldContainSubstring, `hystrix_command_latency_seconds{command="my-circuit",quantile="0.5"} 0.010000`)
+			So(body, ShouldContainSubstring, `hystrix_command_latency_seconds{command="my-circuit",quantile="0.9"} 0.020000`)
+			So(body, ShouldContainSubstring, `hystrix_command_latency_seconds{command="my-circuit",quantile="0.99"} 0.030000`)
+			So(body, ShouldContainSubstring, `hystrix_command_latency_seconds_sum{command="my-circuit"} 0.050000`)
+			So(body, ShouldContainSubstring, `hystrix_command_latency_seconds_count{command="my-circuit"} 10`)
+			So(body, ShouldContainSubstring, "# TYPE hystrix_circuit_open gauge")
+			So(body, ShouldContainSubstring, `hystrix_circuit_open{command="my-circuit"} 1`)
+		})
+	})
+}
@@ -0,0 +1,61 @@
+// Package plugins provides built-in hystrix.MetricCollector
+// implementations for common metrics backends.
+package plugins
+
+import (
+	"fmt"
+	"net"
+
+	metricCollector "github.com/rfloriano/hystrix-go/hystrix/metric_collector"
+)
+
+// StatsdCollector pushes per-circuit gauges to a StatsD daemon over
+// UDP, using the "<prefix>.<circuit>.<metric>" naming convention the
+// reference Hystrix StatsD publisher uses (requests, errors,
+// latency.p99, circuit_open).
+type StatsdCollector struct {
+	prefix string
+	conn   net.Conn
+}
+
+// NewStatsdCollector dials addr ("host:port") once and returns a
+// MetricCollector ready to be passed to hystrix.RegisterCollector.
+func NewStatsdCollector(addr, prefix string) (*StatsdCollector, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("plugins: unable to dial statsd at %s: %v", addr, err)
+	}
+	return &StatsdCollector{prefix: prefix, conn: conn}, nil
+}
+
+// Update implements metricCollector.MetricCollector.
+func (s *StatsdCollector) Update(m metricCollector.CommandMetric) {
+	s.gauge(m.Name, "requests", float64(m.RequestCount))
+	s.gauge(m.Name, "errors", float64(m.ErrorCount))
+	s.gauge(m.Name, "latency.p99", float64(m.LatencyP99))
+	s.gauge(m.Name, "circuit_open", boolToFloat(m.CircuitOpen))
+}
+
+// UpdateThreadPool implements metricCollector.MetricCollector.
+func (s *StatsdCollector) UpdateThreadPool(m metricCollector.ThreadPoolMetric) {
+	s.gauge(m.Name, "thread_pool.active_count", float64(m.ActiveCount))
+}
+
+// Flush implements metricCollector.MetricCollector. Every metric is
+// written to the UDP socket as soon as it is received, so there is
+// nothing to batch.
+func (s *StatsdCollector) Flush() {}
+
+func (s *StatsdCollector) gauge(circuit, metric string, value float64) {
+	line := fmt.Sprintf("%s.%s.%s:%v|g", s.prefix, circuit, metric, value)
+	// StatsD is fire-and-forget; a dropped UDP packet just means one
+	// missed sample, so the write error isn't worth surfacing.
+	s.conn.Write([]byte(line))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+package plugins
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	metricCollector "github.com/rfloriano/hystrix-go/hystrix/metric_collector"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStatsdCollector(t *testing.T) {
+	Convey("given a StatsdCollector dialed to a UDP listener", t, func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer conn.Close()
+
+		s, err := NewStatsdCollector(conn.LocalAddr().String(), "hystrix")
+		So(err, ShouldBeNil)
+
+		recv := func() string {
+			buf := make([]byte, 512)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := conn.ReadFrom(buf)
+			So(err, ShouldBeNil)
+			return string(buf[:n])
+		}
+
+		Convey("Update writes one gauge line per metric, prefixed and namespaced by circuit", func() {
+			s.Update(metricCollector.CommandMetric{
+				Name:         "my-circuit",
+				RequestCount: 5,
+				ErrorCount:   1,
+				LatencyP99:   42,
+				CircuitOpen:  true,
+			})
+
+			So(recv(), ShouldEqual, "hystrix.my-circuit.requests:5|g")
+			So(recv(), ShouldEqual, "hystrix.my-circuit.errors:1|g")
+			So(recv(), ShouldEqual, "hystrix.my-circuit.latency.p99:42|g")
+			So(recv(), ShouldEqual, "hystrix.my-circuit.circuit_open:1|g")
+		})
+
+		Convey("UpdateThreadPool writes the active count gauge", func() {
+			s.UpdateThreadPool(metricCollector.ThreadPoolMetric{Name: "my-circuit", ActiveCount: 3})
+
+			So(recv(), ShouldEqual, "hystrix.my-circuit.thread_pool.active_count:3|g")
+		})
+	})
+}